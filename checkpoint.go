@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// trackedPage is a scan page sent to the data pipe
+type trackedPage struct {
+	segment int64
+	// startKey is the ExclusiveStartKey used to retrieve the page
+	startKey map[string]*dynamodb.AttributeValue
+	// lastKey is the LastEvaluatedKey of the page, nil for the last page
+	lastKey map[string]*dynamodb.AttributeValue
+	// skipped is the number of items of the page already written by a
+	// previous run and thus not sent
+	skipped int64
+	// first is the index in the data pipe of the first item of the page
+	first, count int64
+}
+
+// scanTracker keeps track of the pages sent to the data pipe by the scan
+// segments. As the storage writes the items in the order they are received,
+// it can translate a number of written items into the position of each
+// segment to save in a checkpoint.
+type scanTracker struct {
+	// sendMu makes sure the items of a page are sent contiguously
+	sendMu sync.Mutex
+	mu     sync.Mutex
+	sent   int64
+	pages  []trackedPage
+	// written holds the position of each segment once all of its pages that
+	// are not in pages anymore are written
+	written map[int64]storage.SegmentPosition
+}
+
+// newScanTracker returns a scanTracker for a scan that is not split in segments
+func newScanTracker() *scanTracker {
+	t := &scanTracker{}
+	t.reset(1, 0, nil)
+	return t
+}
+
+// reset prepares the tracker for a scan using the given number of segments.
+// The number of items and the positions of a checkpoint can be given to resume
+// an interrupted scan.
+func (t *scanTracker) reset(segments, items int64, resume []storage.SegmentPosition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = items
+	t.pages = nil
+	t.written = map[int64]storage.SegmentPosition{}
+	for segment := int64(0); segment < segments; segment++ {
+		t.written[segment] = storage.SegmentPosition{Segment: segment}
+	}
+	for _, pos := range resume {
+		t.written[pos.Segment] = pos
+	}
+}
+
+// start returns the position the given segment has to start scanning from
+func (t *scanTracker) start(segment int64) storage.SegmentPosition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.written[segment]
+}
+
+// sendPage sends the items of a page to the data pipe and keeps track of them
+func (t *scanTracker) sendPage(segment int64, startKey, lastKey map[string]*dynamodb.AttributeValue, skipped int64, items []map[string]*dynamodb.AttributeValue, dataPipe chan map[string]*dynamodb.AttributeValue) {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	t.mu.Lock()
+	t.pages = append(t.pages, trackedPage{segment: segment, startKey: startKey, lastKey: lastKey, skipped: skipped, first: t.sent, count: int64(len(items))})
+	t.sent += int64(len(items))
+	t.mu.Unlock()
+	for _, item := range items {
+		dataPipe <- item
+	}
+}
+
+// positions returns the position of each segment once the given number of
+// items sent to the data pipe are written. It is a storage.Checkpointer and
+// expects the number of written items to only grow between calls.
+func (t *scanTracker) positions(items int64) []storage.SegmentPosition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	partial := map[int64]storage.SegmentPosition{}
+	remaining := []trackedPage{}
+	for _, page := range t.pages {
+		switch {
+		case page.first+page.count <= items:
+			t.written[page.segment] = storage.SegmentPosition{Segment: page.segment, StartKey: page.lastKey, Done: page.lastKey == nil}
+		case page.first < items:
+			partial[page.segment] = storage.SegmentPosition{Segment: page.segment, StartKey: page.startKey, Skip: page.skipped + items - page.first}
+			remaining = append(remaining, page)
+		default:
+			remaining = append(remaining, page)
+		}
+	}
+	t.pages = remaining
+
+	result := []storage.SegmentPosition{}
+	for segment, pos := range t.written {
+		if p, ok := partial[segment]; ok {
+			pos = p
+		}
+		result = append(result, pos)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Segment < result[j].Segment })
+	return result
+}
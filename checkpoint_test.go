@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// pagedDataSet is the data set returned page by page by mockPagedDynamoDBClient
+var pagedDataSet = []map[string]*dynamodb.AttributeValue{
+	{"artist": {S: aws.String("Aerosmith")}},
+	{"artist": {S: aws.String("Queen")}},
+	{"artist": {S: aws.String("Metallica")}},
+	{"artist": {S: aws.String("Nirvana")}},
+	{"artist": {S: aws.String("Pixies")}},
+}
+
+// struct to mock a paginated Dynamo scan that honors the ExclusiveStartKey
+type mockPagedDynamoDBClient struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+func (m *mockPagedDynamoDBClient) ScanPages(params *dynamodb.ScanInput, pager func(*dynamodb.ScanOutput, bool) bool) error {
+	idx := 0
+	if params.ExclusiveStartKey != nil {
+		for i, item := range pagedDataSet {
+			if *item["artist"].S == *params.ExclusiveStartKey["artist"].S {
+				idx = i + 1
+			}
+		}
+	}
+	for {
+		end := idx + int(*params.Limit)
+		if end > len(pagedDataSet) {
+			end = len(pagedDataSet)
+		}
+		page := &dynamodb.ScanOutput{
+			ConsumedCapacity: &dynamodb.ConsumedCapacity{CapacityUnits: aws.Float64(1)},
+			Count:            aws.Int64(int64(end - idx)),
+			Items:            pagedDataSet[idx:end],
+		}
+		lastPage := end == len(pagedDataSet)
+		if !lastPage {
+			page.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"artist": pagedDataSet[end-1]["artist"]}
+		}
+		if !pager(page, lastPage) || lastPage {
+			return nil
+		}
+		idx = end
+	}
+}
+
+// scanWithTracker runs a scan of the paged data set using the given tracker
+// and returns the items sent to the data pipe
+func scanWithTracker(t *testing.T, tracker *scanTracker) []map[string]*dynamodb.AttributeValue {
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ParallelTableToChannel(&mockPagedDynamoDBClient{}, "myTable", 2, 0, 1, tracker, nil, nil, dataPipe)
+	}()
+	received := []map[string]*dynamodb.AttributeValue{}
+	for elem := range dataPipe {
+		received = append(received, elem)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Unexpected error during the scan: %s", err)
+	}
+	return received
+}
+
+func TestScanTrackerResume(t *testing.T) {
+	tracker := newScanTracker()
+	if received := scanWithTracker(t, tracker); !reflect.DeepEqual(received, pagedDataSet) {
+		t.Fatalf("Expecting the whole data set, got: %v", received)
+	}
+
+	// 3 items written means the 1st page of 2 items and 1 item of the 2nd page
+	positions := tracker.positions(3)
+	if len(positions) != 1 || *positions[0].StartKey["artist"].S != "Queen" || positions[0].Skip != 1 || positions[0].Done {
+		t.Fatalf("Unexpected positions after 3 items: %+v", positions)
+	}
+	if done := tracker.positions(5); !done[0].Done {
+		t.Errorf("Expecting the segment to be done after 5 items: %+v", done)
+	}
+
+	// Resuming from the checkpoint should only send the items not written yet
+	resumed := newScanTracker()
+	resumed.reset(1, 3, positions)
+	if received := scanWithTracker(t, resumed); !reflect.DeepEqual(received, pagedDataSet[3:]) {
+		t.Errorf("Expecting the items not written yet, got: %v", received)
+	}
+	if done := resumed.positions(5); !done[0].Done {
+		t.Errorf("Expecting the resumed segment to be done after 5 items: %+v", done)
+	}
+}
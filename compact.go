@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	// compactRunSize is the size of the records sorted in memory before
+	// being written in a run file
+	compactRunSize = 64 * 1024 * 1024
+	// compactMergeWidth is the maximum number of run files merged at once
+	compactMergeWidth = 64
+	// compactLineSize is the maximum size of a record, a DynamoDB item being
+	// 400KB max but its json representation being bigger
+	compactLineSize = 5 * 1024 * 1024
+	// compactKeySize is the size of the sort key starting each record: the
+	// hash of the key of the item and the order of the version of the item
+	compactKeySize = 2*len(keyHash{}) + 1 + 16
+)
+
+// The records of the run files are lines made of the hex hash of the key of an
+// item, of the hex position of the version of the item in the backups of the
+// chain and of the item in the json format of the backups. Sorting them sorts
+// the versions of each item from the oldest to the newest.
+
+// runWriter sorts the records it is given in run files of its folder
+type runWriter struct {
+	dir     string
+	records []string
+	size    int
+	runs    []string
+}
+
+// add adds a record, writing a run file once enough records are held
+func (w *runWriter) add(record string) error {
+	w.records = append(w.records, record)
+	w.size += len(record)
+	if w.size >= compactRunSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the records held in a new run file, sorted
+func (w *runWriter) flush() error {
+	if len(w.records) == 0 {
+		return nil
+	}
+	sort.Strings(w.records)
+	name := filepath.Join(w.dir, fmt.Sprintf("run-%05d", len(w.runs)))
+	err := writeRun(name, func(write func(string) error) error {
+		for _, record := range w.records {
+			if err := write(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.runs = append(w.runs, name)
+	w.records, w.size = nil, 0
+	return nil
+}
+
+// writeRun writes the run file of the given name with the records given by
+// fill to its write function
+func writeRun(name string, fill func(write func(string) error) error) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(file)
+	err = fill(func(record string) error {
+		if _, err := out.WriteString(record); err != nil {
+			return err
+		}
+		return out.WriteByte('\n')
+	})
+	if err == nil {
+		err = out.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runReader reads the records of a run file
+type runReader struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	record  string
+}
+
+// next reads the next record, returning false at the end of the file
+func (r *runReader) next() bool {
+	if !r.scanner.Scan() {
+		return false
+	}
+	r.record = r.scanner.Text()
+	return true
+}
+
+// runHeap is a heap of run readers ordered by their current record
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].record < h[j].record }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	reader := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return reader
+}
+
+// mergeGroups merges the given run files, calling fn with the records of each
+// item, oldest first, in the order of the hashes of their keys
+func mergeGroups(runs []string, fn func(records []string) error) error {
+	readers := runHeap{}
+	defer func() {
+		for _, reader := range readers {
+			reader.file.Close()
+		}
+	}()
+	for _, name := range runs {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		reader := &runReader{file: file, scanner: bufio.NewScanner(file)}
+		reader.scanner.Buffer(make([]byte, 64*1024), compactLineSize)
+		if !reader.next() {
+			file.Close()
+			if err = reader.scanner.Err(); err != nil {
+				return err
+			}
+			continue
+		}
+		readers = append(readers, reader)
+	}
+	heap.Init(&readers)
+
+	var group []string
+	for readers.Len() > 0 {
+		reader := readers[0]
+		record := reader.record
+		if len(group) > 0 && group[0][:2*len(keyHash{})] != record[:2*len(keyHash{})] {
+			if err := fn(group); err != nil {
+				return err
+			}
+			group = nil
+		}
+		group = append(group, record)
+		if reader.next() {
+			heap.Fix(&readers, 0)
+			continue
+		}
+		if err := reader.scanner.Err(); err != nil {
+			return err
+		}
+		reader.file.Close()
+		heap.Pop(&readers)
+	}
+	if len(group) > 0 {
+		return fn(group)
+	}
+	return nil
+}
+
+// mergeRuns merges the given run files, calling fn with the newest record of
+// each item in the order of the hashes of their keys
+func mergeRuns(runs []string, fn func(record string) error) error {
+	return mergeGroups(runs, func(records []string) error {
+		return fn(records[len(records)-1])
+	})
+}
+
+// reduceRuns merges the given run files by groups of compactMergeWidth until
+// there are no more of them than compactMergeWidth, and returns them. Only the
+// newest record of each item is kept, or all of them with all.
+func reduceRuns(dir string, runs []string, all bool) ([]string, error) {
+	for level := 0; len(runs) > compactMergeWidth; level++ {
+		merged := []string{}
+		for first := 0; first < len(runs); first += compactMergeWidth {
+			last := first + compactMergeWidth
+			if last > len(runs) {
+				last = len(runs)
+			}
+			name := filepath.Join(dir, fmt.Sprintf("merge-%d-%05d", level, len(merged)))
+			err := writeRun(name, func(write func(string) error) error {
+				if !all {
+					return mergeRuns(runs[first:last], write)
+				}
+				return mergeGroups(runs[first:last], func(records []string) error {
+					for _, record := range records {
+						if err := write(record); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, run := range runs[first:last] {
+				os.Remove(run)
+			}
+			merged = append(merged, name)
+		}
+		runs = merged
+	}
+	return runs, nil
+}
+
+// readBackup calls fn with each item of the complete backup of the given
+// location
+func readBackup(location string, cfg storage.Config, fn func(map[string]*dynamodb.AttributeValue) error) error {
+	var wg sync.WaitGroup
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	cfg.DataPipe = dataPipe
+	store, folder, err := storage.Open(location, &cfg)
+	if err != nil {
+		return err
+	}
+	info, err := store.Describe(folder)
+	if err != nil {
+		return err
+	}
+	if info.Status != storage.StatusComplete {
+		return fmt.Errorf("the backup %s is %s", location, info.Status)
+	}
+	if err = store.LoadManifest(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String(*folder.Path + "/manifest")}); err != nil {
+		return err
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		var err error
+		for item := range dataPipe {
+			if err == nil {
+				err = fn(item)
+			}
+		}
+		errs <- err
+	}()
+	if err = store.WriteToDB("", 0, 0, &wg); err != nil {
+		// WriteToDB only closes the channel once all the files are read
+		close(dataPipe)
+	}
+	if readErr := <-errs; err == nil {
+		err = readErr
+	}
+	return err
+}
+
+// compactBackup writes in the given folder a full backup holding the items of
+// the backup of the given location, merging the incremental backups of its
+// chain with the full backup they are based on. The items are sorted by the
+// hash of their key in run files of a temporary folder of tempDir, or of the
+// default temporary folder if empty, so that the backups don't need to fit in
+// memory. The hashes of the items are recorded like -incremental-diff does.
+// The compacted backup keeps the start of the newest backup of the chain so
+// that the changes made since then are still picked up by -since last and
+// -changes-source.
+func compactBackup(location string, cfg storage.Config, tempDir string, dataPipe chan map[string]*dynamodb.AttributeValue, store storage.BackupIface, folder *storage.FileInput) error {
+	chain, err := backupChain(location, cfg)
+	if err != nil {
+		return fmt.Errorf("unable to find the backups the backup is based on: %s", err)
+	}
+	cfg.Checkpointer = nil
+	base, baseFolder, err := storage.Open(chain[0], &cfg)
+	if err != nil {
+		return err
+	}
+	schema, err := loadSchema(baseFolder, base)
+	if err != nil {
+		return fmt.Errorf("unable to read the schema of the full backup %s: %s", chain[0], err)
+	}
+	newest, newestFolder, err := storage.Open(location, &cfg)
+	if err != nil {
+		return err
+	}
+	manifest, err := newest.ReadManifest(newestFolder)
+	if err != nil {
+		return fmt.Errorf("unable to read the manifest of %s: %s", location, err)
+	}
+	keys := []string{}
+	for _, key := range schema.Table.KeySchema {
+		keys = append(keys, aws.StringValue(key.AttributeName))
+	}
+
+	dir, err := ioutil.TempDir(tempDir, "dynamodbdump-compact")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	runs := &runWriter{dir: dir}
+	var order int64
+	for idx, link := range chain {
+		log.Printf("Sorting the items of the backup %s (%d/%d)\n", link, idx+1, len(chain))
+		err = readBackup(link, cfg, func(item map[string]*dynamodb.AttributeValue) error {
+			key := make(map[string]*dynamodb.AttributeValue, len(keys))
+			for _, name := range keys {
+				key[name] = item[name]
+			}
+			data, err := storage.MarshalDynamoAttributeMap(item)
+			if err != nil {
+				return err
+			}
+			k := hashKey(key)
+			order++
+			return runs.add(fmt.Sprintf("%s %016x %s", hex.EncodeToString(k[:]), order, data))
+		})
+		if err == nil {
+			err = runs.flush()
+		}
+		if err != nil {
+			return fmt.Errorf("while sorting the items of %s: %s", link, err)
+		}
+	}
+	files, err := reduceRuns(dir, runs.runs, false)
+	if err != nil {
+		return fmt.Errorf("while merging the sorted items: %s", err)
+	}
+
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	if err = store.Flush(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *folder.Path, schemaFileName))}, data); err != nil {
+		return fmt.Errorf("unable to write the schema: %s", err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	writeErrs := make(chan error, 1)
+	go func() {
+		writeErrs <- store.Write(folder, 10*1024*1024, &wg)
+	}()
+	merged := make(chan map[string]*dynamodb.AttributeValue)
+	diffErrs := make(chan error, 1)
+	go func() {
+		diffErrs <- (&keyDiff{keys: keys}).run(merged, dataPipe, store, folder)
+	}()
+
+	var items, deleted int64
+	failure := mergeRuns(files, func(record string) error {
+		item := map[string]*dynamodb.AttributeValue{}
+		if err := json.Unmarshal([]byte(record[compactKeySize+1:]), &item); err != nil {
+			return err
+		}
+		change, rest, err := storage.SplitChange(item)
+		if err != nil {
+			return err
+		}
+		if change != nil && change.Event == storage.EventRemove {
+			deleted++
+			return nil
+		}
+		items++
+		merged <- rest
+		return nil
+	})
+	close(merged)
+	if err = <-diffErrs; failure == nil {
+		failure = err
+	}
+	wg.Wait()
+	if err = <-writeErrs; failure == nil {
+		failure = err
+	}
+	if manifest != nil {
+		store.SetManifestInfo(storage.ManifestInfo{Start: manifest.Start})
+	}
+	if failure == nil {
+		log.Printf("Compacted %d backups into %d items, %d deleted items left out\n", len(chain), items, deleted)
+	}
+	return store.Commit(folder, failure)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestReduceRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dynamodbdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Each run holds a version of 3 items, the newest versions being in the
+	// last runs
+	runs := &runWriter{dir: dir}
+	count := 2*compactMergeWidth + 1
+	for run := 0; run < count; run++ {
+		for key := 0; key < 3; key++ {
+			if err = runs.add(fmt.Sprintf("%032d %016x version %d", key, run, run)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = runs.flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := reduceRuns(dir, runs.runs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) > compactMergeWidth {
+		t.Errorf("Expecting at most %d runs, got %d", compactMergeWidth, len(files))
+	}
+	records := []string{}
+	if err = mergeRuns(files, func(record string) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{}
+	for key := 0; key < 3; key++ {
+		expected = append(expected, fmt.Sprintf("%032d %016x version %d", key, count-1, count-1))
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expecting the newest version of each item %v, got %v", expected, records)
+	}
+
+	// Keeps all the versions of each item, oldest first
+	runs = &runWriter{dir: dir}
+	for run := 0; run < count; run++ {
+		for key := 0; key < 3; key++ {
+			if err = runs.add(fmt.Sprintf("%032d %016x version %d", key, run, run)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = runs.flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, err = reduceRuns(dir, runs.runs, true); err != nil {
+		t.Fatal(err)
+	}
+	groups := 0
+	if err = mergeGroups(files, func(records []string) error {
+		groups++
+		for run, record := range records {
+			if record != fmt.Sprintf("%s %016x version %d", record[:32], run, run) {
+				return fmt.Errorf("unexpected record %d of %s: %s", run, record[:32], record)
+			}
+		}
+		if len(records) != count {
+			return fmt.Errorf("expecting %d versions of %s, got %d", count, records[0][:32], len(records))
+		}
+		return nil
+	}); err != nil || groups != 3 {
+		t.Errorf("Expecting all the versions of the 3 items, got %d items, %v", groups, err)
+	}
+}
+
+func TestCompactBackup(t *testing.T) {
+	queen := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Queen")}, "songs": {SS: aws.StringSlice([]string{"Bohemian Rhapsody"})}}
+	blondie := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Blondie")}, "songs": {SS: aws.StringSlice([]string{"Call me"})}}
+	location := "mem://tests/compact/myTable"
+	for idx, items := range [][]map[string]*dynamodb.AttributeValue{dataSet, {dataSet[0], queen, blondie}, {queen, blondie}} {
+		dynamoSvc = &mockDiffDynamoDBClient{items: items}
+		c := make(chan map[string]*dynamodb.AttributeValue)
+		store, folder, err := storage.Open(location, &storage.Config{DataPipe: c})
+		if err != nil {
+			t.Fatal(err)
+		}
+		incremental, err := newIncrementalBackup(incrementalSettings{diff: true}, store, folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = backupTable("myTable", 10, 0, 1, *folder.Bucket, fmt.Sprintf("%s/2019-01-0%d-02-00-00", *folder.Path, idx+1), false, false, throughputSettings{}, incremental, newScanTracker(), c, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "dynamodbdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := storage.Open("mem://tests/compact/compacted", &storage.Config{DataPipe: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = compactBackup(location+"/2019-01-03-02-00-00", storage.Config{}, dir, c, store, folder); err != nil {
+		t.Fatal(err)
+	}
+	if items := backupItems(t, "mem://tests/compact/compacted"); !reflect.DeepEqual(items, []string{"Blondie", "Queen"}) {
+		t.Errorf("Expecting the items left by the last backup, got %v", items)
+	}
+	info, err := store.Describe(folder)
+	if err != nil || info.Status != storage.StatusComplete {
+		t.Fatalf("Expecting a complete backup, got %+v, %v", info, err)
+	}
+	if chain, err := backupChain(info.URL, storage.Config{}); err != nil || len(chain) != 1 {
+		t.Errorf("Expecting a full backup, got the chain %v, %v", chain, err)
+	}
+	if files, err := hashesFiles(store, folder); err != nil || len(files) != 1 {
+		t.Errorf("Expecting the hashes of the items to be recorded, got %v, %v", files, err)
+	}
+	if _, err = loadSchema(folder, store); err != nil {
+		t.Errorf("Expecting the schema to be copied, got %s", err)
+	}
+	newest, err := store.ReadManifest(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String("compact/myTable/2019-01-03-02-00-00")})
+	if err != nil || newest == nil || newest.Start == nil {
+		t.Fatalf("Expecting the manifest of the newest backup, got %+v, %v", newest, err)
+	}
+	if manifest, err := store.ReadManifest(folder); err != nil || manifest == nil || manifest.Start == nil || !manifest.Start.Equal(*newest.Start) {
+		t.Errorf("Expecting the compacted backup to start with the newest backup at %s, got %+v, %v", newest.Start, manifest, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("Expecting the temporary files to be removed, got %v", files)
+	}
+}
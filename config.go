@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// jobFile is the content of the -config file. The settings of the defaults
+// and of the jobs are named after the command-line flags.
+type jobFile struct {
+	Defaults map[string]interface{}   `yaml:"defaults"`
+	Jobs     []map[string]interface{} `yaml:"jobs"`
+}
+
+// job is one of the jobs of the -config file
+type job struct {
+	name string
+	opts *options
+}
+
+// settingValue converts a setting of the -config file to the value of its
+// flag, expanding the environment variables of the strings. A list is joined
+// with commas.
+func settingValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return os.ExpandEnv(v), nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			s, err := settingValue(item)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, s)
+		}
+		return strings.Join(values, ","), nil
+	default:
+		return "", fmt.Errorf("unexpected value %v", value)
+	}
+}
+
+// applySettings sets the flags of the given flag set from the given settings,
+// except the ones overridden
+func applySettings(fs *flag.FlagSet, settings map[string]interface{}, overrides map[string]string) error {
+	keys := []string{}
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if _, ok := overrides[key]; ok {
+			continue
+		}
+		value, err := settingValue(settings[key])
+		if err == nil {
+			err = fs.Set(key, value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// parseJobs returns the jobs of the given -config file content, or only the
+// one of the given name if any. The options of each job are taken first from
+// the given overrides, which are the flags set on the command line or by the
+// environment, then from the settings of the job and then from the defaults of
+// the file.
+func parseJobs(data []byte, name string, overrides map[string]string) ([]job, error) {
+	file := jobFile{}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("no job found")
+	}
+
+	jobs := []job{}
+	names := map[string]bool{}
+	for idx, settings := range file.Jobs {
+		j := job{name: fmt.Sprintf("#%d", idx+1), opts: &options{}}
+		if value, ok := settings["name"]; ok {
+			j.name = fmt.Sprint(value)
+			delete(settings, "name")
+		}
+		if names[j.name] {
+			return nil, fmt.Errorf("duplicate job name %q", j.name)
+		}
+		names[j.name] = true
+		if name != "" && j.name != name {
+			continue
+		}
+
+		fs := flag.NewFlagSet(j.name, flag.ContinueOnError)
+		j.opts.register(fs)
+		if err := applySettings(fs, file.Defaults, overrides); err != nil {
+			return nil, fmt.Errorf("defaults: %s", err)
+		}
+		if err := applySettings(fs, settings, overrides); err != nil {
+			return nil, fmt.Errorf("job %s: %s", j.name, err)
+		}
+		for key, value := range overrides {
+			if err := fs.Set(key, value); err != nil {
+				return nil, fmt.Errorf("job %s: invalid value for %s: %s", j.name, key, err)
+			}
+		}
+		jobs = append(jobs, j)
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("no job named %q found", name)
+	}
+	return jobs, nil
+}
+
+// loadJobs reads the given -config file and returns its jobs as parseJobs does
+func loadJobs(path, name string, overrides map[string]string) ([]job, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := parseJobs(data, name, overrides)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJobFile = `
+defaults:
+  batch-size: 500
+  compression: zstd
+  target-utilization: 0.5
+jobs:
+  - name: songs
+    dynamo-table: songs
+    target: s3://${TEST_BACKUP_BUCKET}/songs
+    s3-date-folder: true
+  - name: prod
+    dynamo-tables: [prod-a, "prod-b*"]
+    target: s3://${TEST_BACKUP_BUCKET}/prod
+    batch-size: 100
+  - name: prune-songs
+    action: prune
+    target: s3://${TEST_BACKUP_BUCKET}/songs
+    keep-daily: 7
+    keep-within: 30d
+`
+
+func TestParseJobs(t *testing.T) {
+	os.Setenv("TEST_BACKUP_BUCKET", "mybucket")
+	defer os.Unsetenv("TEST_BACKUP_BUCKET")
+
+	jobs, err := parseJobs([]byte(testJobFile), "", map[string]string{"wait-ms": "20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 3 || jobs[0].name != "songs" || jobs[1].name != "prod" || jobs[2].name != "prune-songs" {
+		t.Fatalf("Unexpected jobs: %+v", jobs)
+	}
+	for _, j := range jobs {
+		if err := j.opts.validate(); err != nil {
+			t.Errorf("Job %s: %s", j.name, err)
+		}
+		if j.opts.waitTime != 20 || j.opts.compression != "zstd" || j.opts.throughput.utilization != 0.5 {
+			t.Errorf("Expecting the defaults and the overrides in job %s, got %+v", j.name, j.opts)
+		}
+	}
+	songs, prod, prune := jobs[0].opts, jobs[1].opts, jobs[2].opts
+	if songs.action != "backup" || songs.tableName != "songs" || songs.location != "s3://mybucket/songs" || !songs.s3DateSuffix || songs.batchSize != 500 {
+		t.Errorf("Unexpected options for the songs job: %+v", songs)
+	}
+	if prod.tableSelection != "prod-a,prod-b*" || prod.batchSize != 100 {
+		t.Errorf("Unexpected options for the prod job: %+v", prod)
+	}
+	if prune.retention.daily != 7 || prune.retention.within != 30*24*time.Hour {
+		t.Errorf("Unexpected options for the prune job: %+v", prune)
+	}
+
+	jobs, err = parseJobs([]byte(testJobFile), "prod", nil)
+	if err != nil || len(jobs) != 1 || jobs[0].name != "prod" {
+		t.Errorf("Expecting only the prod job, got %+v, %v", jobs, err)
+	}
+
+	// The invalid batch size of the last job is overridden
+	jobs, err = parseJobs([]byte(testJobFile+"    batch-size: many\n"), "", map[string]string{"batch-size": "10"})
+	if err != nil || len(jobs) != 3 || jobs[1].opts.batchSize != 10 || jobs[2].opts.batchSize != 10 {
+		t.Errorf("Expecting the overridden batch size in all the jobs, got %+v, %v", jobs, err)
+	}
+}
+
+func TestParseJobsErrors(t *testing.T) {
+	testCases := map[string]string{
+		"jobs: []":                                    "no job found",
+		"jobs:\n  - dynamo-tabel: songs":              `unknown setting "dynamo-tabel"`,
+		"jobs:\n  - batch-size: many":                 "invalid value for batch-size",
+		"jobs:\n  - s3-date-folder: {a: b}":           "unexpected value",
+		"jobs:\n  - name: a\n  - name: a":             `duplicate job name "a"`,
+		"defaults:\n  job: a\njobs:\n  - name: a":     `defaults: unknown setting "job"`,
+		"jobs:\n  - name: a\nunknown: true":           "field unknown not found",
+		"jobs:\n  - name: a\n    dynamo-table: [a, b": "did not find expected",
+	}
+	for data, expected := range testCases {
+		if _, err := parseJobs([]byte(data), "", nil); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expecting an error containing %q for %q, got %v", expected, data, err)
+		}
+	}
+	if _, err := parseJobs([]byte("jobs:\n  - name: a"), "b", nil); err == nil {
+		t.Errorf("Expecting an error for an unknown job name")
+	}
+}
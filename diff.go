@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// hashesFilePrefix is the prefix of the files of a backup made with
+	// -incremental-diff holding, for every item of the table, its key
+	// attributes along with the hash of its content in the hashAttribute
+	hashesFilePrefix = "_HASHES-"
+	// hashAttribute is the attribute of the lines of the hashes files
+	// holding the hash of the content of the item
+	hashAttribute = "_dynamodbdump_hash"
+	// hashesFileSize is the size from which a hashes file is written
+	hashesFileSize = 10 * 1024 * 1024
+)
+
+// keyHash is the hash of the key attributes of an item
+type keyHash [16]byte
+
+// keyDiff finds the items changed, added or deleted since a previous backup
+// by comparing the hashes of their keys and content with the ones recorded in
+// the hashes files of the previous backup. Both are sorted by the hash of the
+// key in run files of a temporary folder, like -action compact does, so that
+// the hashes don't need to fit in memory.
+type keyDiff struct {
+	// keys are the names of the key attributes of the table
+	keys []string
+	// parent is the folder of the previous backup, nil for a full backup
+	parent *storage.FileInput
+	time   time.Time
+}
+
+// writeValue writes a canonical representation of the given value to h: the
+// members of the sets and the attributes of the maps are sorted so that the
+// same value always gives the same hash
+func writeValue(h hash.Hash, value *dynamodb.AttributeValue) {
+	sorted := func(values []*string) []string {
+		s := aws.StringValueSlice(values)
+		sort.Strings(s)
+		return s
+	}
+	switch {
+	case value == nil:
+		fmt.Fprint(h, "0")
+	case value.S != nil:
+		fmt.Fprintf(h, "S%q", *value.S)
+	case value.N != nil:
+		fmt.Fprintf(h, "N%q", *value.N)
+	case value.B != nil:
+		fmt.Fprintf(h, "B%q", value.B)
+	case value.BOOL != nil:
+		fmt.Fprintf(h, "T%t", *value.BOOL)
+	case value.NULL != nil:
+		fmt.Fprint(h, "Z")
+	case value.SS != nil:
+		fmt.Fprintf(h, "SS%q", sorted(value.SS))
+	case value.NS != nil:
+		fmt.Fprintf(h, "NS%q", sorted(value.NS))
+	case value.BS != nil:
+		members := make([]string, 0, len(value.BS))
+		for _, member := range value.BS {
+			members = append(members, string(member))
+		}
+		sort.Strings(members)
+		fmt.Fprintf(h, "BS%q", members)
+	case value.L != nil:
+		fmt.Fprintf(h, "L%d", len(value.L))
+		for _, member := range value.L {
+			writeValue(h, member)
+		}
+	case value.M != nil:
+		fmt.Fprint(h, "M")
+		writeItem(h, value.M)
+	}
+}
+
+// writeItem writes a canonical representation of the given item to h
+func writeItem(h hash.Hash, item map[string]*dynamodb.AttributeValue) {
+	names := make([]string, 0, len(item))
+	for name := range item {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(h, "%d", len(names))
+	for _, name := range names {
+		fmt.Fprintf(h, "%q", name)
+		writeValue(h, item[name])
+	}
+}
+
+// hashKey returns the hash of the given key attributes
+func hashKey(key map[string]*dynamodb.AttributeValue) keyHash {
+	h := sha256.New()
+	writeItem(h, key)
+	var sum keyHash
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// hashContent returns the hash of the content of the given item
+func hashContent(item map[string]*dynamodb.AttributeValue) string {
+	h := sha256.New()
+	writeItem(h, item)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// tableKeys returns the names of the key attributes of the given table
+func tableKeys(svc dynamodbiface.DynamoDBAPI, tableName string) ([]string, error) {
+	var result *dynamodb.DescribeTableOutput
+	err := retry.do(func() (err error) {
+		result, err = svc.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, key := range result.Table.KeySchema {
+		keys = append(keys, aws.StringValue(key.AttributeName))
+	}
+	return keys, nil
+}
+
+// hashesFiles returns the hashes files of the given backup folder, in the
+// order they were written
+func hashesFiles(store storage.BackupIface, folder *storage.FileInput) ([]*storage.FileInput, error) {
+	files, err := store.ListFiles(folder)
+	if err != nil {
+		return nil, err
+	}
+	found := []*storage.FileInput{}
+	for _, file := range files {
+		if strings.HasPrefix(path.Base(*file.Path), hashesFilePrefix) {
+			found = append(found, file)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return *found[i].Path < *found[j].Path })
+	return found, nil
+}
+
+// readHashes calls fn with the key attributes and the content hash of each
+// line of the given hashes files
+func readHashes(store storage.BackupIface, files []*storage.FileInput, fn func(key map[string]*dynamodb.AttributeValue, hash string) error) error {
+	for _, file := range files {
+		reader, err := store.GetFile(file)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(*reader)
+		for scanner.Scan() {
+			key := map[string]*dynamodb.AttributeValue{}
+			if err = json.Unmarshal(scanner.Bytes(), &key); err != nil || key[hashAttribute] == nil {
+				storage.Close(*reader)
+				return fmt.Errorf("invalid line in %s: %s", *file.Path, scanner.Text())
+			}
+			hash := aws.StringValue(key[hashAttribute].S)
+			delete(key, hashAttribute)
+			if err = fn(key, hash); err != nil {
+				storage.Close(*reader)
+				return err
+			}
+		}
+		err = scanner.Err()
+		storage.Close(*reader)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newKeyDiffBackup returns the incremental backup holding the differences
+// with the latest complete backup of the date folders of the given folder. It
+// is a full backup if there is no such backup or if it has no hashes files.
+func newKeyDiffBackup(store storage.BackupIface, folder *storage.FileInput) (*incrementalBackup, error) {
+	incremental := &incrementalBackup{diff: &keyDiff{time: time.Now().UTC()}}
+	parent, err := store.FindBackup(folder, time.Time{})
+	if err != nil {
+		log.Printf("Making a full backup as the previous one can't be found: %s\n", err)
+		return incremental, nil
+	}
+	info, err := store.Describe(parent.Folder)
+	if err != nil {
+		return nil, err
+	}
+	files, err := hashesFiles(store, parent.Folder)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the files of the previous backup: %s", err)
+	}
+	if len(files) == 0 {
+		log.Printf("Making a full backup as the previous backup %s has no hashes of its items\n", info.URL)
+		return incremental, nil
+	}
+	incremental.diff.parent = parent.Folder
+	incremental.info = &storage.Incremental{Parent: info.URL, Since: parent.Time, KeyDiff: true}
+	if manifest, err := store.ReadManifest(parent.Folder); err == nil && manifest != nil && manifest.Start != nil {
+		incremental.info.Since = *manifest.Start
+	}
+	log.Printf("Backing up the differences with the backup %s\n", info.URL)
+	return incremental, nil
+}
+
+// The records of the run files of the diff are lines made of the hex hash of
+// the key of an item, of 0 for the previous backup or 1 for the table (both in
+// hex like the order of the records of -action compact), of the hex hash of the
+// content of the item and of the item in the json format of the backups, only
+// its key attributes for the previous backup. Sorting them puts the previous
+// version of each item before the current one.
+
+// diffRecord returns the record of the run files of the diff of the given item
+func diffRecord(key keyHash, current bool, hash string, item map[string]*dynamodb.AttributeValue) (string, error) {
+	data, err := storage.MarshalDynamoAttributeMap(item)
+	if err != nil {
+		return "", err
+	}
+	order := 0
+	if current {
+		order = 1
+	}
+	return fmt.Sprintf("%s %016x %s %s", hex.EncodeToString(key[:]), order, hash, data), nil
+}
+
+// run reads the items scanned from the table and sends to dataPipe the ones
+// that are new or changed since the previous backup, marked with a change,
+// and the keys of the deleted items marked with a removal. Without a previous
+// backup, all the items are sent as is. The hashes of all the items are
+// written in the hashes files of the given folder. dataPipe is closed once
+// done.
+func (d *keyDiff) run(scanned, dataPipe chan map[string]*dynamodb.AttributeValue, store storage.BackupIface, folder *storage.FileInput) error {
+	defer close(dataPipe)
+	var buff bytes.Buffer
+	var failure error
+	files := 0
+	flush := func() {
+		files++
+		file := &storage.FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s%05d", *folder.Path, hashesFilePrefix, files))}
+		if err := store.Flush(file, buff.Bytes()); err != nil {
+			failure = fmt.Errorf("while writing the hashes file %s: %s", *file.Path, err)
+			log.Printf("[ERROR] %s\n", failure)
+		}
+		buff.Reset()
+	}
+
+	var runs *runWriter
+	if d.parent != nil {
+		dir, err := ioutil.TempDir("", "dynamodbdump-diff")
+		if err != nil {
+			failure = fmt.Errorf("unable to create the folder of the sorted hashes: %s", err)
+			log.Printf("[ERROR] %s\n", failure)
+		} else {
+			defer os.RemoveAll(dir)
+			runs = &runWriter{dir: dir}
+		}
+	}
+	for item := range scanned {
+		if d.parent == nil {
+			dataPipe <- item
+		}
+		// Keeps reading the scanned items so that the scan is not blocked
+		if failure != nil {
+			continue
+		}
+		key := make(map[string]*dynamodb.AttributeValue, len(d.keys)+1)
+		for _, name := range d.keys {
+			key[name] = item[name]
+		}
+		k, hash := hashKey(key), hashContent(item)
+		key[hashAttribute] = &dynamodb.AttributeValue{S: aws.String(hash)}
+		line, err := storage.MarshalDynamoAttributeMap(key)
+		if err != nil {
+			failure = fmt.Errorf("while converting the key to json: %v\nError: %s", key, err)
+			log.Printf("[ERROR] %s\n", failure)
+			continue
+		}
+		buff.Write(line)
+		buff.WriteString("\n")
+		if buff.Len() >= hashesFileSize {
+			flush()
+		}
+		if runs != nil && failure == nil {
+			record, err := diffRecord(k, true, hash, item)
+			if err == nil {
+				err = runs.add(record)
+			}
+			if err != nil {
+				failure = fmt.Errorf("while sorting the hashes of the items: %s", err)
+				log.Printf("[ERROR] %s\n", failure)
+			}
+		}
+	}
+	// Always writes a file so that an empty table is not taken for a backup
+	// without hashes
+	if failure == nil && (buff.Len() > 0 || files == 0) {
+		flush()
+	}
+	if d.parent == nil || failure != nil {
+		return failure
+	}
+
+	previous, err := hashesFiles(store, d.parent)
+	if err == nil {
+		err = readHashes(store, previous, func(key map[string]*dynamodb.AttributeValue, hash string) error {
+			record, err := diffRecord(hashKey(key), false, hash, key)
+			if err != nil {
+				return err
+			}
+			return runs.add(record)
+		})
+	}
+	if err == nil {
+		err = runs.flush()
+	}
+	if err != nil {
+		return fmt.Errorf("while sorting the hashes of the previous backup: %s", err)
+	}
+	sorted, err := reduceRuns(runs.dir, runs.runs, true)
+	if err != nil {
+		return fmt.Errorf("while merging the sorted hashes: %s", err)
+	}
+
+	var added, changed, deleted int64
+	hashStart := compactKeySize + 1
+	err = mergeGroups(sorted, func(records []string) error {
+		first, last := records[0], records[len(records)-1]
+		event := storage.EventModify
+		switch {
+		case first[hashStart-2] == '1':
+			event = storage.EventInsert
+			added++
+		case last[hashStart-2] == '0':
+			event = storage.EventRemove
+			deleted++
+		case first[hashStart:hashStart+32] == last[hashStart:hashStart+32]:
+			return nil
+		default:
+			changed++
+		}
+		item := map[string]*dynamodb.AttributeValue{}
+		if err := json.Unmarshal([]byte(last[hashStart+33:]), &item); err != nil {
+			return err
+		}
+		dataPipe <- storage.MarkChange(item, storage.Change{Event: event, Time: d.time, Keys: d.keys})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("while comparing the hashes with the previous backup: %s", err)
+	}
+	log.Printf("Found %d new, %d changed and %d deleted items since the previous backup\n", added, changed, deleted)
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// mockDiffDynamoDBClient scans the given items of the test table
+type mockDiffDynamoDBClient struct {
+	mockSchemaDynamoDBClient
+	items []map[string]*dynamodb.AttributeValue
+}
+
+func (m *mockDiffDynamoDBClient) ScanPages(params *dynamodb.ScanInput, pager func(*dynamodb.ScanOutput, bool) bool) error {
+	pager(&dynamodb.ScanOutput{Count: aws.Int64(int64(len(m.items))), Items: m.items}, true)
+	return nil
+}
+
+// backupItems returns the items of the backup of the given location, as
+// "<event> <artist>" for the items holding a change and "<artist>" otherwise
+func backupItems(t *testing.T, location string) []string {
+	var wg sync.WaitGroup
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := storage.Open(location, &storage.Config{DataPipe: dataPipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.LoadManifest(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String(*folder.Path + "/manifest")}); err != nil {
+		t.Fatal(err)
+	}
+	go store.WriteToDB("myTable", 25, 0, &wg)
+	items := []string{}
+	for item := range dataPipe {
+		change, rest, err := storage.SplitChange(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if change != nil {
+			items = append(items, change.Event+" "+*rest["artist"].S)
+			continue
+		}
+		items = append(items, *item["artist"].S)
+	}
+	sort.Strings(items)
+	return items
+}
+
+func TestHashContent(t *testing.T) {
+	item := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Queen")}, "songs": {SS: aws.StringSlice([]string{"Under pressure", "Somebody to love"})}}
+	reordered := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Queen")}, "songs": {SS: aws.StringSlice([]string{"Somebody to love", "Under pressure"})}}
+	if hashContent(item) != hashContent(reordered) {
+		t.Error("Expecting the order of the members of a set not to change the hash")
+	}
+	changed := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Queen")}, "songs": {SS: aws.StringSlice([]string{"Under pressure"})}}
+	if hashContent(item) == hashContent(changed) {
+		t.Error("Expecting different items to have different hashes")
+	}
+}
+
+func TestKeyDiffBackup(t *testing.T) {
+	queen := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Queen")}, "songs": {SS: aws.StringSlice([]string{"Bohemian Rhapsody"})}}
+	blondie := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Blondie")}, "songs": {SS: aws.StringSlice([]string{"Call me"})}}
+	testCases := []struct {
+		folder   string
+		items    []map[string]*dynamodb.AttributeValue
+		expected []string
+	}{
+		{folder: "2019-01-01-02-00-00", items: dataSet, expected: []string{"Aerosmith", "Metallica", "Queen"}},
+		{folder: "2019-01-02-02-00-00", items: []map[string]*dynamodb.AttributeValue{dataSet[0], queen, blondie}, expected: []string{"INSERT Blondie", "MODIFY Queen", "REMOVE Metallica"}},
+		{folder: "2019-01-03-02-00-00", items: []map[string]*dynamodb.AttributeValue{blondie, queen, dataSet[0]}, expected: []string{}},
+	}
+	location := "mem://tests/diff/myTable"
+	var previous string
+	for _, tc := range testCases {
+		dynamoSvc = &mockDiffDynamoDBClient{items: tc.items}
+		c := make(chan map[string]*dynamodb.AttributeValue)
+		store, folder, err := storage.Open(location, &storage.Config{DataPipe: c})
+		if err != nil {
+			t.Fatal(err)
+		}
+		incremental, err := newIncrementalBackup(incrementalSettings{diff: true}, store, folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = backupTable("myTable", 10, 0, 1, *folder.Bucket, *folder.Path+"/"+tc.folder, false, false, throughputSettings{}, incremental, newScanTracker(), c, store); err != nil {
+			t.Fatal(err)
+		}
+
+		current := location + "/" + tc.folder
+		if items := backupItems(t, current); !reflect.DeepEqual(items, tc.expected) {
+			t.Errorf("Expecting %v in %s, got %v", tc.expected, tc.folder, items)
+		}
+		chain, err := backupChain(current, storage.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if previous != "" && chain[len(chain)-2] != previous {
+			t.Errorf("Expecting %s to be based on %s, got %v", current, previous, chain)
+		}
+		previous = current
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// overrideTagPrefix is the prefix of the tags of a table overriding its
+// backup settings, for example dynamodbdump:batch-size=500
+const overrideTagPrefix = "dynamodbdump:"
+
+// tableBackup describes the backup of one of the tables of a multi-table
+// backup
+type tableBackup struct {
+	Table string
+	// Folder is the sub-folder of the target folder where the table is
+	// backed up
+	Folder       string
+	BatchSize    int64
+	WaitPeriod   time.Duration
+	ScanSegments int64
+	// Error is the reason why the table can't be backed up, such as an
+	// invalid dynamodbdump: tag. The table is then reported as failed.
+	Error string
+}
+
+// defaultBackups returns the backups of the given tables, each in the
+// sub-folder named after it, using the settings of the given backup
+func defaultBackups(tables []string, settings tableBackup) []tableBackup {
+	backups := []tableBackup{}
+	for _, table := range tables {
+		backup := settings
+		backup.Table, backup.Folder = table, table
+		backups = append(backups, backup)
+	}
+	return backups
+}
+
+// parseTagFilters parses a comma separated list of key=value tag filters. A
+// key given without value matches any value of the tag.
+func parseTagFilters(value string) (map[string]*string, error) {
+	filters := map[string]*string{}
+	for _, filter := range strings.Split(value, ",") {
+		filter = strings.TrimSpace(filter)
+		if filter == "" {
+			continue
+		}
+		parts := strings.SplitN(filter, "=", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid tag filter %q, expecting key=value or key", filter)
+		}
+		filters[parts[0]] = nil
+		if len(parts) == 2 {
+			filters[parts[0]] = aws.String(parts[1])
+		}
+	}
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("no tag filter given")
+	}
+	return filters, nil
+}
+
+// matchTags returns whether the given tags match all the filters
+func matchTags(tags []*dynamodb.Tag, filters map[string]*string) bool {
+	values := map[string]string{}
+	for _, tag := range tags {
+		values[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	for key, expected := range filters {
+		value, ok := values[key]
+		if !ok || (expected != nil && *expected != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyOverrides updates the given backup with the settings found in the
+// dynamodbdump: tags of its table: batch-size, wait-ms, scan-segments and
+// folder, the latter being relative to the target folder
+func applyOverrides(backup *tableBackup, tags []*dynamodb.Tag) error {
+	for _, tag := range tags {
+		key, value := aws.StringValue(tag.Key), aws.StringValue(tag.Value)
+		if !strings.HasPrefix(key, overrideTagPrefix) {
+			continue
+		}
+		var err error
+		switch name := strings.TrimPrefix(key, overrideTagPrefix); name {
+		case "batch-size":
+			backup.BatchSize, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && backup.BatchSize < 1 {
+				err = fmt.Errorf("expecting a positive number")
+			}
+		case "wait-ms":
+			var waitTime int64
+			waitTime, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && waitTime < 0 {
+				err = fmt.Errorf("expecting a number of milliseconds")
+			}
+			backup.WaitPeriod = time.Duration(waitTime) * time.Millisecond
+		case "scan-segments":
+			backup.ScanSegments, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && backup.ScanSegments < 1 {
+				err = fmt.Errorf("expecting a positive number")
+			}
+		case "folder":
+			backup.Folder = path.Clean(strings.TrimPrefix(value, "/"))
+			if backup.Folder == "." || strings.HasPrefix(backup.Folder, "..") {
+				err = fmt.Errorf("expecting a sub-folder of the target folder")
+			}
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tag %s=%s: %s", key, value, err)
+		}
+	}
+	return nil
+}
+
+// failedBackup returns the backup of the given table, using the settings of
+// the given backup, reported as failed with the given error
+func failedBackup(table string, settings tableBackup, err error) tableBackup {
+	log.Printf("[ERROR] The table %s won't be backed up: %s\n", table, err)
+	backup := defaultBackups([]string{table}, settings)[0]
+	backup.Error = err.Error()
+	return backup
+}
+
+// checkFolders reports as failed the backups sharing their folder with
+// another backup, as they would overwrite each other
+func checkFolders(backups []tableBackup) {
+	tables := map[string][]string{}
+	for _, backup := range backups {
+		tables[backup.Folder] = append(tables[backup.Folder], backup.Table)
+	}
+	for idx, backup := range backups {
+		if shared := tables[backup.Folder]; len(shared) > 1 && backup.Error == "" {
+			backups[idx].Error = fmt.Sprintf("the folder %s is used by the tables %s", backup.Folder, strings.Join(shared, ", "))
+			log.Printf("[ERROR] The table %s won't be backed up: %s\n", backup.Table, backups[idx].Error)
+		}
+	}
+}
+
+// discoverTables returns the backups of the given tables, or of all the
+// tables if none is given, whose tags match all the given filters. The
+// settings of the given backup are overridden by the dynamodbdump: tags of
+// each table. A table that can't be described or has invalid dynamodbdump:
+// tags is returned with its Error set so that it is reported as failed
+// without stopping the others.
+func discoverTables(svc dynamodbiface.DynamoDBAPI, tables []string, filters map[string]*string, settings tableBackup) ([]tableBackup, error) {
+	if tables == nil {
+		var err error
+		if tables, err = listTables(svc); err != nil {
+			return nil, fmt.Errorf("unable to list the tables: %s", err)
+		}
+	}
+	backups := []tableBackup{}
+	for _, table := range tables {
+		var result *dynamodb.DescribeTableOutput
+		err := retry.do(func() (err error) {
+			result, err = svc.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(table)})
+			return err
+		})
+		if err != nil {
+			backups = append(backups, failedBackup(table, settings, fmt.Errorf("unable to describe the table: %s", err)))
+			continue
+		}
+		tags, err := tableTags(svc, result.Table.TableArn)
+		if err != nil {
+			backups = append(backups, failedBackup(table, settings, fmt.Errorf("unable to retrieve the tags of the table: %s", err)))
+			continue
+		}
+		if !matchTags(tags, filters) {
+			continue
+		}
+		backup := defaultBackups([]string{table}, settings)[0]
+		if err = applyOverrides(&backup, tags); err != nil {
+			backup = failedBackup(table, settings, err)
+		}
+		backups = append(backups, backup)
+	}
+	if len(backups) == 0 {
+		return nil, fmt.Errorf("no table has matching tags")
+	}
+	return backups, nil
+}
+
+// tableBackups returns the backups of the tables selected by the given
+// -dynamo-tables and -dynamo-table-tags values, either of which can be empty
+func tableBackups(svc dynamodbiface.DynamoDBAPI, selection, tagFilters string, settings tableBackup) ([]tableBackup, error) {
+	var tables []string
+	if selection != "" {
+		var err error
+		if tables, err = selectTables(svc, selection); err != nil {
+			return nil, err
+		}
+	}
+	if tagFilters == "" {
+		return defaultBackups(tables, settings), nil
+	}
+	filters, err := parseTagFilters(tagFilters)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := discoverTables(svc, tables, filters, settings)
+	if err != nil {
+		return nil, err
+	}
+	checkFolders(backups)
+	return backups, nil
+}
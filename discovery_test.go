@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// struct to mock the Dynamo calls of the discovery of the tables by tags
+type mockTaggedDynamoDBClient struct {
+	mockTablesDynamoDBClient
+	tags map[string]map[string]string
+	// deleted are the tables deleted since they were listed
+	deleted map[string]bool
+}
+
+func (m *mockTaggedDynamoDBClient) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	if m.deleted[*input.TableName] {
+		return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Requested resource not found", nil)
+	}
+	return &dynamodb.DescribeTableOutput{Table: &dynamodb.TableDescription{TableName: input.TableName, TableArn: aws.String("arn:aws:dynamodb:us-east-1:123456789012:table/" + *input.TableName)}}, nil
+}
+
+func (m *mockTaggedDynamoDBClient) ListTagsOfResource(input *dynamodb.ListTagsOfResourceInput) (*dynamodb.ListTagsOfResourceOutput, error) {
+	output := &dynamodb.ListTagsOfResourceOutput{}
+	for key, value := range m.tags[*input.ResourceArn] {
+		output.Tags = append(output.Tags, &dynamodb.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return output, nil
+}
+
+func TestDiscoverTables(t *testing.T) {
+	arn := "arn:aws:dynamodb:us-east-1:123456789012:table/"
+	svc := &mockTaggedDynamoDBClient{tags: map[string]map[string]string{
+		arn + "prod-a":    {"backup": "daily", "dynamodbdump:batch-size": "50", "dynamodbdump:folder": "/team-a/prod-a"},
+		arn + "prod-b":    {"backup": "weekly"},
+		arn + "prod-c":    {"backup": "daily", "team": "c", "dynamodbdump:wait-ms": "20", "dynamodbdump:scan-segments": "4"},
+		arn + "staging-a": {"backup": "daily", "dynamodbdump:batch-size": "-1"},
+	}}
+	defaults := tableBackup{BatchSize: 1000, WaitPeriod: 100 * time.Millisecond, ScanSegments: 1}
+
+	backups, err := tableBackups(svc, "prod-*", "backup=daily", defaults)
+	expected := []tableBackup{
+		{Table: "prod-a", Folder: "team-a/prod-a", BatchSize: 50, WaitPeriod: 100 * time.Millisecond, ScanSegments: 1},
+		{Table: "prod-c", Folder: "prod-c", BatchSize: 1000, WaitPeriod: 20 * time.Millisecond, ScanSegments: 4},
+	}
+	if err != nil || !reflect.DeepEqual(backups, expected) {
+		t.Errorf("Expecting %+v, got %+v, %v", expected, backups, err)
+	}
+
+	backups, err = tableBackups(svc, "", "backup=daily,team", defaults)
+	if err != nil || len(backups) != 1 || backups[0].Table != "prod-c" {
+		t.Errorf("Expecting only prod-c to have both tags, got %+v, %v", backups, err)
+	}
+
+	// All the tables are listed, including staging-a with an invalid override
+	// which is kept to be reported as failed
+	backups, err = tableBackups(svc, "", "backup=daily", defaults)
+	if err != nil || len(backups) != 3 {
+		t.Fatalf("Expecting the 3 daily tables, got %+v, %v", backups, err)
+	}
+	for _, backup := range backups {
+		if (backup.Error != "") != (backup.Table == "staging-a") {
+			t.Errorf("Expecting only staging-a to be in error, got %+v", backup)
+		}
+	}
+	if _, err = tableBackups(svc, "", "backup=monthly", defaults); err == nil {
+		t.Errorf("Expecting an error when no table matches")
+	}
+
+	// A deleted table is reported as failed, as well as the tables backed up
+	// in the same folder
+	svc.tags[arn+"prod-c"]["dynamodbdump:folder"] = "team-a/prod-a"
+	svc.deleted = map[string]bool{"prod-d": true}
+	backups, err = tableBackups(svc, "prod-a,prod-b,prod-c,prod-d", "backup", defaults)
+	if err != nil || len(backups) != 4 {
+		t.Fatalf("Expecting the 4 tables, got %+v, %v", backups, err)
+	}
+	for _, backup := range backups {
+		failed := backup.Table == "prod-a" || backup.Table == "prod-c" || backup.Table == "prod-d"
+		if (backup.Error != "") != failed {
+			t.Errorf("Unexpected backup of %s: %+v", backup.Table, backup)
+		}
+	}
+	if !strings.Contains(backups[3].Error, "unable to describe") || !strings.Contains(backups[0].Error, "prod-a, prod-c") {
+		t.Errorf("Unexpected errors: %q, %q", backups[3].Error, backups[0].Error)
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	testCases := map[string]bool{
+		"dynamodbdump:batch-size=10":   true,
+		"dynamodbdump:batch-size=ten":  false,
+		"dynamodbdump:wait-ms=0":       true,
+		"dynamodbdump:wait-ms=-5":      false,
+		"dynamodbdump:scan-segments=0": false,
+		"dynamodbdump:folder=a/b":      true,
+		"dynamodbdump:folder=../a":     false,
+		"dynamodbdump:folder=/":        false,
+		"dynamodbdump:unknown=1":       false,
+		"other:batch-size=ten":         true,
+	}
+	for tag, valid := range testCases {
+		parts := strings.SplitN(tag, "=", 2)
+		backup := tableBackup{}
+		err := applyOverrides(&backup, []*dynamodb.Tag{{Key: aws.String(parts[0]), Value: aws.String(parts[1])}})
+		if (err == nil) != valid {
+			t.Errorf("Unexpected result for %s: %v", tag, err)
+		}
+	}
+}
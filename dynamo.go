@@ -6,39 +6,73 @@ import (
 	"sync"
 	"time"
 
+	"github.com/VEVO/dynamodbdump/storage"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
-// dynamoErrorCheck checks for the error output and waits the given waitError in
-// case of ProvisionedThroughputExceededException
-func dynamoErrorCheck(err error, waitError time.Duration) error {
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeProvisionedThroughputExceededException:
-				log.Printf("[WARNING] ProvisionedThroughputExceededException encountered, will wait %d before retrying: %s", waitError, aerr.Error())
-				// This one is recoverable, just wait the waitTime before retrying
-				time.Sleep(waitError)
-			default:
-				return aerr
+// TableToChannel scans an entire DynamoDB table, putting all the output records to a
+// given channel and increment a given waitgroup
+func TableToChannel(svc dynamodbiface.DynamoDBAPI, tableName string, batchSize int64, waitPeriod time.Duration, dataPipe chan map[string]*dynamodb.AttributeValue) error {
+	return ParallelTableToChannel(svc, tableName, batchSize, waitPeriod, 1, nil, nil, nil, dataPipe)
+}
+
+// ParallelTableToChannel scans an entire DynamoDB table using the given number
+// of segments, each of them being scanned by its own goroutine. All the output
+// records are put in the given channel, which is closed once every segment is
+// done. The first error encountered by a segment is returned.
+// If a tracker is given, the segments start from the position it holds and
+// report the pages they send to it. If a throughput controller is given, it
+// paces the segments instead of waitPeriod. If a filter is given, only the
+// items matching it are scanned.
+func ParallelTableToChannel(svc dynamodbiface.DynamoDBAPI, tableName string, batchSize int64, waitPeriod time.Duration, segments int64, tracker *scanTracker, throughput *throughputController, filter *scanFilter, dataPipe chan map[string]*dynamodb.AttributeValue) error {
+	var wg sync.WaitGroup
+	if segments < 1 {
+		segments = 1
+	}
+	errs := make(chan error, segments)
+	for segment := int64(0); segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int64) {
+			defer wg.Done()
+			if err := segmentToChannel(svc, tableName, batchSize, waitPeriod, segment, segments, tracker, throughput, filter, dataPipe); err != nil {
+				log.Printf("[ERROR] while scanning segment %d of %d: %s\n", segment, segments, err)
+				errs <- err
 			}
-		} else {
-			return err
-		}
+		}(segment)
 	}
-	return nil
+	wg.Wait()
+	close(errs)
+	close(dataPipe)
+	return <-errs
 }
 
-// TableToChannel scans an entire DynamoDB table, putting all the output records to a
-// given channel and increment a given waitgroup
-func TableToChannel(svc dynamodbiface.DynamoDBAPI, tableName string, batchSize int64, waitPeriod time.Duration, dataPipe chan map[string]*dynamodb.AttributeValue) error {
+// segmentToChannel scans a single segment of a DynamoDB table (or the whole
+// table when totalSegments is lower than 2), putting all the output records to
+// the given channel. The segment keeps track of its own LastEvaluatedKey so
+// that it resumes where it stopped after a retryable error.
+// With a throughput controller, each page is requested with a Limit computed
+// from the measured capacity consumed per item and followed by a wait matching
+// the capacity it consumed, the Limit and the capacity applying to the items
+// evaluated before the filter if any.
+func segmentToChannel(svc dynamodbiface.DynamoDBAPI, tableName string, batchSize int64, waitPeriod time.Duration, segment, totalSegments int64, tracker *scanTracker, throughput *throughputController, filter *scanFilter, dataPipe chan map[string]*dynamodb.AttributeValue) error {
 	var errChk error
 	stopScan := false
+	retries := retry.newBackoff()
 	var lastEvaluatedKey map[string]*dynamodb.AttributeValue
-	// var results []map[string]*dynamodb.AttributeValue
+	// skip is the number of items already written by a previous run
+	var skip int64
+	if tracker != nil {
+		pos := tracker.start(segment)
+		if pos.Done {
+			log.Printf("Segment %d already done by a previous run\n", segment)
+			return nil
+		}
+		lastEvaluatedKey = pos.StartKey
+		skip = pos.Skip
+	}
 	// Looping to recover on errors
 	for !stopScan {
 		params := &dynamodb.ScanInput{
@@ -47,31 +81,63 @@ func TableToChannel(svc dynamodbiface.DynamoDBAPI, tableName string, batchSize i
 		}
 
 		// Limit only accepts an int64 >= 1
-		if batchSize > 0 {
+		if throughput != nil {
+			params.Limit = aws.Int64(throughput.scanLimit(batchSize, totalSegments))
+		} else if batchSize > 0 {
 			params.Limit = aws.Int64(batchSize)
 		}
+		// Segment and TotalSegments have to be provided together
+		if totalSegments > 1 {
+			params.Segment = aws.Int64(segment)
+			params.TotalSegments = aws.Int64(totalSegments)
+		}
 		// This is how we recover on basic errors
 		if lastEvaluatedKey != nil {
 			params.ExclusiveStartKey = lastEvaluatedKey
 		}
+		filter.apply(params)
 
 		err := svc.ScanPages(params,
 			func(page *dynamodb.ScanOutput, lastPage bool) bool {
-				log.Printf("Items: %d, Capacity consumed: %f", *page.Count, *page.ConsumedCapacity.CapacityUnits)
-				for _, res := range page.Items {
-					dataPipe <- res
+				retries.reset()
+				capacity := consumedCapacity([]*dynamodb.ConsumedCapacity{page.ConsumedCapacity})
+				log.Printf("Segment: %d, Items: %d, Capacity consumed: %f", segment, *page.Count, capacity)
+				items := page.Items
+				skipped := skip
+				if skipped > int64(len(items)) {
+					skipped = int64(len(items))
 				}
-				time.Sleep(waitPeriod)
+				items = items[skipped:]
+				skip -= skipped
+				if tracker != nil {
+					tracker.sendPage(segment, lastEvaluatedKey, page.LastEvaluatedKey, skipped, items, dataPipe)
+				} else {
+					for _, res := range items {
+						dataPipe <- res
+					}
+				}
+				lastEvaluatedKey = page.LastEvaluatedKey
 				stopScan = lastPage
+				if throughput != nil {
+					evaluated := *page.Count
+					if page.ScannedCount != nil {
+						evaluated = *page.ScannedCount
+					}
+					throughput.consumed(capacity, evaluated)
+					throughput.waitCapacity(capacity)
+					// Stops the pagination to request the next page with an
+					// up to date Limit
+					return false
+				}
+				time.Sleep(waitPeriod)
 				return !lastPage
 			})
 
 		// Error handling
-		if errChk = dynamoErrorCheck(err, waitPeriod*2); errChk != nil {
+		if errChk = retries.retry(err); errChk != nil {
 			break
 		}
 	}
-	close(dataPipe)
 	return errChk
 }
 
@@ -84,7 +150,11 @@ func CheckTableEmpty(svc dynamodbiface.DynamoDBAPI, tbl string) (int64, error) {
 		TableName: aws.String(tbl),
 	}
 
-	result, err := svc.DescribeTable(input)
+	var result *dynamodb.DescribeTableOutput
+	err := retry.do(func() (err error) {
+		result, err = svc.DescribeTable(input)
+		return err
+	})
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
 			return -1, nil
@@ -106,74 +176,204 @@ func CheckTableEmpty(svc dynamodbiface.DynamoDBAPI, tbl string) (int64, error) {
 	}
 }
 
+// writeRequest returns the request writing the given item of the data pipe.
+// The items of a change archive are written without their change attribute,
+// or deleted for the EventRemove changes, and their key is also returned so
+// that a batch never holds 2 requests on the same item.
+func writeRequest(item map[string]*dynamodb.AttributeValue) (*dynamodb.WriteRequest, string, error) {
+	change, rest, err := storage.SplitChange(item)
+	if err != nil || change == nil {
+		return &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}}, "", err
+	}
+	var key string
+	if keyAttributes := change.Key(rest); keyAttributes != nil {
+		data, err := storage.MarshalDynamoAttributeMap(keyAttributes)
+		if err != nil {
+			return nil, "", err
+		}
+		key = string(data)
+	}
+	if change.Event == storage.EventRemove {
+		return &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: rest}}, key, nil
+	}
+	return &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: rest}}, key, nil
+}
+
 // channelToWriteRequests polls from the channel and create an array of
 // WriteRequests to be passed to a BatchWriteItem. If (globalIndex - batchSize)
-// is less than 25 this will be the batch size. Else it'll be 25
+// is less than 25 this will be the batch size. Else it'll be 25.
+// It also returns the number of items read from the channel, which is greater
+// than the number of requests when a change replaces a previous change of the
+// same item in the batch, or when an item holds an invalid change.
 //
 // Note that the following criteria will be rejected by the AWS SDK:
 // * Any individual item in a batch exceeds 400 KB.
 // * The total request size exceeds 16 MB.
-func channelToWriteRequests(batchSize, globalIndex int64, dataPipe chan map[string]*dynamodb.AttributeValue) []*dynamodb.WriteRequest {
-	idx := 0
+func channelToWriteRequests(batchSize, globalIndex int64, dataPipe chan map[string]*dynamodb.AttributeValue) ([]*dynamodb.WriteRequest, int64) {
+	var idx int64
 	dataReq := []*dynamodb.WriteRequest{}
+	keys := map[string]int{}
 	for elem := range dataPipe {
-		req := dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: elem}}
-		dataReq = append(dataReq, &req)
 		idx++
+		req, key, err := writeRequest(elem)
+		switch {
+		case err != nil:
+			log.Printf("[ERROR] skipping %v: %s\n", elem, err)
+		case key == "":
+			dataReq = append(dataReq, req)
+		default:
+			// Only the last change of an item matters within a batch
+			if previous, ok := keys[key]; ok {
+				dataReq[previous] = req
+			} else {
+				keys[key] = len(dataReq)
+				dataReq = append(dataReq, req)
+			}
+		}
 		// A BatchWriteItem should not have more than 25 WriteRequests
-		if idx >= 25 || batchSize <= (globalIndex+int64(idx)) {
+		if idx >= 25 || batchSize <= (globalIndex+idx) {
 			break
 		}
 	}
-	return dataReq
+	return dataReq, idx
 }
 
-// batchToTable sends a BatchWriteItem to Dynamo
-func batchToTable(svc dynamodbiface.DynamoDBAPI, wRequest map[string][]*dynamodb.WriteRequest, waitRetry time.Duration) {
-	input := &dynamodb.BatchWriteItemInput{
-		ReturnConsumedCapacity: aws.String("TOTAL"),
-		RequestItems:           wRequest,
-	}
-	result, err := svc.BatchWriteItem(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeProvisionedThroughputExceededException:
-				log.Printf("[WARNING] ProvisionedThroughputExceededException encountered. Waiting %d before retrying...\n", waitRetry)
-			case dynamodb.ErrCodeItemCollectionSizeLimitExceededException:
+// batchToTable sends a BatchWriteItem to Dynamo and returns the capacity
+// consumed. The retryable errors and the unprocessed items are retried
+// according to the retry policy.
+func batchToTable(svc dynamodbiface.DynamoDBAPI, wRequest map[string][]*dynamodb.WriteRequest) (float64, error) {
+	var capacity float64
+	retries := retry.newBackoff()
+	for len(wRequest) > 0 {
+		input := &dynamodb.BatchWriteItemInput{
+			ReturnConsumedCapacity: aws.String("TOTAL"),
+			RequestItems:           wRequest,
+		}
+		result, err := svc.BatchWriteItem(input)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeItemCollectionSizeLimitExceededException {
 				log.Println("[WARNING] An item collection is too large. This exception is only returned for tables that have one or more local secondary indexes. Skip collection.")
-			default:
-				log.Fatalf("[ERROR] unrecoverable error during batch write: %s\n", aerr.Error())
+				return capacity, nil
+			}
+			if err = retries.retry(err); err != nil {
+				return capacity, fmt.Errorf("unrecoverable error during batch write: %s", err)
 			}
-		} else {
-			log.Fatalf("[ERROR] unrecoverable error during batch write: %s\n", aerr.Error())
+			continue
 		}
+
+		capacity += consumedCapacity(result.ConsumedCapacity)
+		log.Printf("Unprocessed items: %d, Capacity consumed: %f\n", len(result.UnprocessedItems), consumedCapacity(result.ConsumedCapacity))
+		if len(result.UnprocessedItems) == 0 {
+			break
+		}
+		// Unprocessed items are the sign of a throttling on part of the batch
+		if unprocessed(result.UnprocessedItems) < unprocessed(wRequest) {
+			retries.reset()
+		}
+		if err = retries.retry(awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, fmt.Sprintf("%d unprocessed items", unprocessed(result.UnprocessedItems)), nil)); err != nil {
+			return capacity, fmt.Errorf("unable to write the unprocessed items: %s", err)
+		}
+		wRequest = result.UnprocessedItems
 	}
+	return capacity, nil
+}
 
-	log.Printf("Unprocessed items: %d, Capacity consumed: %f\n", len(result.UnprocessedItems), *(result.ConsumedCapacity[0].CapacityUnits))
-	if len(result.UnprocessedItems) > 0 {
-		time.Sleep(waitRetry)
-		batchToTable(svc, result.UnprocessedItems, waitRetry)
+// unprocessed returns the number of write requests of the given request items
+func unprocessed(requests map[string][]*dynamodb.WriteRequest) int {
+	count := 0
+	for _, reqs := range requests {
+		count += len(reqs)
 	}
+	return count
+}
+
+// writeBatch is a set of write requests sent by ChannelToTable to its workers
+type writeBatch struct {
+	// first is the position in the channel of the first item of the batch
+	// and count the number of items of the channel it holds
+	first, count int64
+	requests     []*dynamodb.WriteRequest
 }
 
 // ChannelToTable puts the data from the channel into the given Dynamo table
-func ChannelToTable(svc dynamodbiface.DynamoDBAPI, tableName string, batchSize int64, waitPeriod time.Duration, dataPipe chan map[string]*dynamodb.AttributeValue, wg *sync.WaitGroup) {
-	var currentIdx int64
-	currentIdx = 0
+// using the given number of workers writing in parallel. The workers are paced
+// by the given throughput controller or, if nil, share a rate limiter allowing
+// batchSize items every waitPeriod.
+// If ack is not nil, it is called after each batch with the position in the
+// channel of the first item of the batch and the number of items written.
+// The first error encountered by a worker is returned once the channel is
+// closed, the remaining items being read but not written.
+func ChannelToTable(svc dynamodbiface.DynamoDBAPI, tableName string, batchSize int64, waitPeriod time.Duration, workers int, throughput *throughputController, dataPipe chan map[string]*dynamodb.AttributeValue, ack func(first, count int64), wg *sync.WaitGroup) error {
+	var workersWg sync.WaitGroup
+	var errOnce sync.Once
+	var writeErr error
+	failed := make(chan struct{})
+	limiter := newBatchRateLimiter(batchSize, waitPeriod)
+	if workers < 1 {
+		workers = 1
+	}
+	batches := make(chan writeBatch)
+	for worker := 0; worker < workers; worker++ {
+		workersWg.Add(1)
+		go func(worker int) {
+			defer workersWg.Done()
+			for batch := range batches {
+				select {
+				case <-failed:
+					// Stops writing after a failure of any worker
+					continue
+				default:
+				}
+				if len(batch.requests) == 0 {
+					// Only invalid items
+					if ack != nil {
+						ack(batch.first, batch.count)
+					}
+					continue
+				}
+				reqSize := int64(len(batch.requests))
+				if throughput != nil {
+					throughput.waitItems(reqSize)
+				} else {
+					limiter.wait(float64(reqSize))
+				}
+				log.Printf("Worker %d sending %d items\n", worker, reqSize)
+				// The UnprocessedItems are retried by the worker
+				capacity, err := batchToTable(svc, map[string][]*dynamodb.WriteRequest{tableName: batch.requests})
+				if err != nil {
+					log.Printf("[ERROR] Worker %d: %s\n", worker, err)
+					errOnce.Do(func() {
+						writeErr = err
+						close(failed)
+					})
+					continue
+				}
+				if throughput != nil {
+					throughput.consumed(capacity, reqSize)
+				}
+				if ack != nil {
+					ack(batch.first, batch.count)
+				}
+			}
+		}(worker)
+	}
+
+	var sent int64
 	for {
-		dataReq := channelToWriteRequests(batchSize, currentIdx, dataPipe)
-		reqSize := len(dataReq)
-		if reqSize == 0 {
+		dataReq, count := channelToWriteRequests(batchSize, 0, dataPipe)
+		if count == 0 {
 			break // Leaves if the queue is closed and no items were found
 		}
-		log.Printf("Sending %d items\n", reqSize)
-		batchToTable(svc, map[string][]*dynamodb.WriteRequest{tableName: dataReq}, waitPeriod*2)
-		currentIdx += int64(reqSize)
-		if currentIdx >= batchSize {
-			time.Sleep(waitPeriod)
-			currentIdx = 0
+		select {
+		case <-failed:
+			// Drains the channel so that its producer is not blocked
+		default:
+			batches <- writeBatch{first: sent, count: count, requests: dataReq}
 		}
+		sent += count
 	}
+	close(batches)
+	workersWg.Wait()
 	wg.Done()
+	return writeErr
 }
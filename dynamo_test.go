@@ -1,14 +1,13 @@
 package main
 
 import (
-	"fmt"
 	"reflect"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/VEVO/dynamodbdump/storage"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
@@ -23,38 +22,65 @@ var dataSet = []map[string]*dynamodb.AttributeValue{
 // struct to mock the Dynamo calls
 type mockDynamoDBClient struct {
 	dynamodbiface.DynamoDBAPI
+	mu      sync.Mutex
+	written []map[string]*dynamodb.AttributeValue
+	deleted []map[string]*dynamodb.AttributeValue
 }
 
 func (m *mockDynamoDBClient) ScanPages(params *dynamodb.ScanInput, pager func(*dynamodb.ScanOutput, bool) bool) error {
-	dsSize := int64(len(dataSet))
+	items := dataSet
+	// In case of a segmented scan, only return the items of the given segment
+	if params.TotalSegments != nil {
+		items = []map[string]*dynamodb.AttributeValue{}
+		for idx, item := range dataSet {
+			if int64(idx)%*params.TotalSegments == *params.Segment {
+				items = append(items, item)
+			}
+		}
+	}
 	dataOut := dynamodb.ScanOutput{
 		ConsumedCapacity: &dynamodb.ConsumedCapacity{CapacityUnits: aws.Float64(23), TableName: params.TableName},
-		Count:            aws.Int64(dsSize),
-		Items:            dataSet,
+		Count:            aws.Int64(int64(len(items))),
+		Items:            items,
 	}
 	pager(&dataOut, true)
 	return nil
 }
 
+func (m *mockDynamoDBClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, requests := range input.RequestItems {
+		for _, req := range requests {
+			if req.DeleteRequest != nil {
+				m.deleted = append(m.deleted, req.DeleteRequest.Key)
+				continue
+			}
+			m.written = append(m.written, req.PutRequest.Item)
+		}
+	}
+	return &dynamodb.BatchWriteItemOutput{ConsumedCapacity: []*dynamodb.ConsumedCapacity{{CapacityUnits: aws.Float64(1)}}}, nil
+}
+
 func TestTableToChannel(t *testing.T) {
 	var wg sync.WaitGroup
 	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
 
 	// Consumer
 	go func() {
+		defer wg.Done()
 		// Checks that all the elements of the channel are part of the dataSet
 		idx := 0
 		for elem := range dataPipe {
 			if !reflect.DeepEqual(elem, dataSet[idx]) {
-				t.Fatalf("Element %d in the channel mismatch. Expecting: %v\nGot: %v\n", idx, dataSet[idx], elem)
+				t.Errorf("Element %d in the channel mismatch. Expecting: %v\nGot: %v\n", idx, dataSet[idx], elem)
 			}
 			idx++
 		}
 		// Checks that all the elements of the dataSet have been parsed
 		if idx != len(dataSet) {
-			t.Fatalf("Size of the dataSet is %d, only got %d elements from the channel\n", len(dataSet), idx)
+			t.Errorf("Size of the dataSet is %d, only got %d elements from the channel\n", len(dataSet), idx)
 		}
-		wg.Done()
 	}()
 
 	wg.Add(1)
@@ -62,17 +88,113 @@ func TestTableToChannel(t *testing.T) {
 	wg.Wait()
 }
 
-func TestDynamoErrorCheck(t *testing.T) {
-	errorTest := []struct{ inputErr, expectedOut error }{
-		{inputErr: nil, expectedOut: nil},
-		{inputErr: fmt.Errorf("Random error"), expectedOut: fmt.Errorf("Random error")},
-		{inputErr: awserr.New(dynamodb.ErrCodeInternalServerError, "Bla bla", nil), expectedOut: awserr.New(dynamodb.ErrCodeInternalServerError, "Bla bla", nil)},
-		{inputErr: awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "Bla bla", nil), expectedOut: nil},
-	}
-	for _, item := range errorTest {
-		returnedOut := dynamoErrorCheck(item.inputErr, 1)
-		if !reflect.DeepEqual(returnedOut, item.expectedOut) {
-			t.Fatalf("Input %v should return %v. Got: %v\n", item.inputErr, item.expectedOut, returnedOut)
+func TestParallelTableToChannel(t *testing.T) {
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- ParallelTableToChannel(&mockDynamoDBClient{}, "myTable", 10, time.Millisecond, 2, nil, nil, nil, dataPipe)
+	}()
+
+	// The segments are scanned in parallel so the order is not guaranteed
+	received := []map[string]*dynamodb.AttributeValue{}
+	for elem := range dataPipe {
+		received = append(received, elem)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Unexpected error during the parallel scan: %s", err)
+	}
+	if len(received) != len(dataSet) {
+		t.Fatalf("Size of the dataSet is %d, got %d elements from the channel\n", len(dataSet), len(received))
+	}
+	for _, expected := range dataSet {
+		found := false
+		for _, elem := range received {
+			if reflect.DeepEqual(elem, expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Element %v not found in the channel", expected)
 		}
 	}
 }
+
+func TestChannelToTable(t *testing.T) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	svc := &mockDynamoDBClient{}
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	acked := map[int64]int64{}
+	ack := func(first, count int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		acked[first] = count
+	}
+
+	wg.Add(1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ChannelToTable(svc, "myTable", 2, 0, 3, nil, dataPipe, ack, &wg)
+	}()
+	for i := 0; i < 3; i++ {
+		for _, item := range dataSet {
+			dataPipe <- item
+		}
+	}
+	close(dataPipe)
+	wg.Wait()
+	if err := <-errc; err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	if len(svc.written) != 3*len(dataSet) {
+		t.Errorf("Expecting %d items written, got %d", 3*len(dataSet), len(svc.written))
+	}
+	// Batches of 2 items so 9 items are acknowledged in 5 batches
+	expected := map[int64]int64{0: 2, 2: 2, 4: 2, 6: 2, 8: 1}
+	if !reflect.DeepEqual(acked, expected) {
+		t.Errorf("Unexpected acknowledgements. Expecting: %v\nGot: %v", expected, acked)
+	}
+}
+
+func TestChannelToTableChanges(t *testing.T) {
+	var wg sync.WaitGroup
+	svc := &mockDynamoDBClient{}
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	at := time.Date(2019, 1, 1, 2, 0, 0, 0, time.UTC)
+	keys := []string{"artist"}
+	modified := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Aerosmith")}, "songs": {SS: aws.StringSlice([]string{"Dream On"})}}
+	removed := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Queen")}}
+	items := []map[string]*dynamodb.AttributeValue{
+		storage.MarkChange(dataSet[0], storage.Change{Event: storage.EventInsert, Time: at, Keys: keys}),
+		storage.MarkChange(dataSet[1], storage.Change{Event: storage.EventInsert, Time: at, Keys: keys}),
+		storage.MarkChange(modified, storage.Change{Event: storage.EventModify, Time: at, Keys: keys}),
+		storage.MarkChange(removed, storage.Change{Event: storage.EventRemove, Time: at, Keys: keys}),
+		{storage.ChangeAttribute: {S: aws.String("invalid")}},
+		dataSet[2],
+	}
+
+	wg.Add(1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ChannelToTable(svc, "myTable", 25, 0, 1, nil, dataPipe, nil, &wg)
+	}()
+	for _, item := range items {
+		dataPipe <- item
+	}
+	close(dataPipe)
+	wg.Wait()
+	if err := <-errc; err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Only the last change of each item is written in a batch
+	if expected := []map[string]*dynamodb.AttributeValue{modified, dataSet[2]}; !reflect.DeepEqual(svc.written, expected) {
+		t.Errorf("Expecting the items %v to be written, got %v", expected, svc.written)
+	}
+	if expected := []map[string]*dynamodb.AttributeValue{removed}; !reflect.DeepEqual(svc.deleted, expected) {
+		t.Errorf("Expecting the items %v to be deleted, got %v", expected, svc.deleted)
+	}
+}
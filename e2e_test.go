@@ -0,0 +1,111 @@
+//go:build e2e
+// +build e2e
+
+package main
+
+// End-to-end tests running the backups and restores against DynamoDB Local and
+// an S3 compatible service such as MinIO. They are only built with the e2e tag
+// and skipped unless E2E_DYNAMO_ENDPOINT and E2E_S3_ENDPOINT are set. See the
+// e2e target of the Makefile.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// e2eEnv returns the session and the endpoints of the end-to-end tests, or
+// skips the test if they are not set
+func e2eEnv(t *testing.T) (*session.Session, string, string) {
+	dynamoEndpoint, s3Endpoint := os.Getenv("E2E_DYNAMO_ENDPOINT"), os.Getenv("E2E_S3_ENDPOINT")
+	if dynamoEndpoint == "" || s3Endpoint == "" {
+		t.Skip("E2E_DYNAMO_ENDPOINT and E2E_S3_ENDPOINT are not set")
+	}
+	sess, err := session.NewSession(&aws.Config{Region: aws.String("us-east-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sess, dynamoEndpoint, s3Endpoint
+}
+
+// e2eOptions returns the validated options given by the given flags
+func e2eOptions(t *testing.T, args ...string) *options {
+	o := &options{}
+	fs := flag.NewFlagSet("e2e", flag.ContinueOnError)
+	o.register(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// scanAll returns the artists of all the items of the given table
+func scanAll(t *testing.T, svc *dynamodb.DynamoDB, tableName string) []string {
+	artists := []string{}
+	err := svc.ScanPages(&dynamodb.ScanInput{TableName: aws.String(tableName)}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			artists = append(artists, aws.StringValue(item["artist"].S))
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(artists)
+	return artists
+}
+
+func TestE2EBackupRestore(t *testing.T) {
+	sess, dynamoEndpoint, s3Endpoint := e2eEnv(t)
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	source, restored, bucket := "e2e-source-"+suffix, "e2e-restored-"+suffix, "e2e-"+suffix
+
+	ddb := dynamodb.New(sess, &aws.Config{Endpoint: aws.String(dynamoEndpoint)})
+	_, err := ddb.CreateTable(&dynamodb.CreateTableInput{
+		TableName:             aws.String(source),
+		AttributeDefinitions:  []*dynamodb.AttributeDefinition{{AttributeName: aws.String("artist"), AttributeType: aws.String("S")}},
+		KeySchema:             []*dynamodb.KeySchemaElement{{AttributeName: aws.String("artist"), KeyType: aws.String("HASH")}},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(5), WriteCapacityUnits: aws.Int64(5)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ddb.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(source)})
+	defer ddb.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(restored)})
+	if err = ddb.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(source)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range dataSet {
+		if _, err = ddb.PutItem(&dynamodb.PutItemInput{TableName: aws.String(source), Item: item}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s3Svc := s3.New(sess, &aws.Config{Endpoint: aws.String(s3Endpoint), S3ForcePathStyle: aws.Bool(true)})
+	if _, err = s3Svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints := []string{"-dynamo-endpoint", dynamoEndpoint, "-s3-endpoint", s3Endpoint, "-s3-force-path-style", "-wait-ms", "0"}
+	location := fmt.Sprintf("s3://%s/backups/%s", bucket, source)
+	run(e2eOptions(t, append(endpoints, "-dynamo-table", source, "-target", location, "-s3-date-folder", "-compression", "zstd")...), sess)
+	run(e2eOptions(t, append(endpoints, "-action", "verify", "-source", location, "-restore-latest")...), sess)
+	run(e2eOptions(t, append(endpoints, "-action", "restore", "-dynamo-table", restored, "-source", location, "-restore-latest", "-restore-create-table")...), sess)
+
+	expected := []string{"Aerosmith", "Metallica", "Queen"}
+	if artists := scanAll(t, ddb, restored); !reflect.DeepEqual(artists, expected) {
+		t.Errorf("Expecting %v in the restored table, got %v", expected, artists)
+	}
+}
@@ -5,7 +5,9 @@ go 1.13
 require (
 	github.com/aws/aws-sdk-go v1.25.48
 	github.com/gobike/envflag v0.0.0-20160830095501-ae3268980a29
+	github.com/klauspost/compress v1.10.10
 	github.com/segmentio/ksuid v1.0.3-0.20190623195011-9349bd0a1b74
 	github.com/stretchr/testify v1.4.0 // indirect
 	golang.org/x/net v0.0.0-20191119073136-fc4aabc6c914 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
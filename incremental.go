@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Formats of the values of the -incremental-attribute
+const (
+	incrementalRFC3339 = "rfc3339"
+	incrementalEpoch   = "epoch"
+	incrementalEpochMs = "epoch-ms"
+)
+
+// incrementalSettings are the options of the incremental backups
+type incrementalSettings struct {
+	attribute, format string
+	// since is either a timestamp or "last" for the start of the latest
+	// complete backup
+	since string
+	// diff is set for the incremental backups found by comparing the items
+	// with the ones of the previous backup
+	diff bool
+}
+
+// validate returns an error if the settings can't be used for the given
+// action
+func (s incrementalSettings) validate(action string, addDate bool) error {
+	switch {
+	case action != "backup":
+		return fmt.Errorf("-incremental-attribute, -since and -incremental-diff can only be used for a backup")
+	case s.diff && (s.attribute != "" || s.since != ""):
+		return fmt.Errorf("-incremental-diff can't be used with -incremental-attribute or -since")
+	case s.diff && !addDate:
+		return fmt.Errorf("-incremental-diff requires -s3-date-folder")
+	case s.diff:
+		return nil
+	case s.attribute == "" || s.since == "":
+		return fmt.Errorf("an incremental backup requires both -incremental-attribute and -since")
+	case s.format != incrementalRFC3339 && s.format != incrementalEpoch && s.format != incrementalEpochMs:
+		return fmt.Errorf("unknown -incremental-format %q, expecting '%s', '%s' or '%s'", s.format, incrementalRFC3339, incrementalEpoch, incrementalEpochMs)
+	case !addDate:
+		// The parent backup is found in the date folders
+		return fmt.Errorf("-since %s requires -s3-date-folder", s.since)
+	}
+	if s.since != "last" {
+		if _, err := parseTimestamp(s.since); err != nil {
+			return fmt.Errorf("-since: %s", err)
+		}
+	}
+	return nil
+}
+
+// scanFilter restricts a scan to the items matching a filter expression
+type scanFilter struct {
+	expression string
+	names      map[string]*string
+	values     map[string]*dynamodb.AttributeValue
+}
+
+// apply adds the filter to the given scan, if any
+func (f *scanFilter) apply(params *dynamodb.ScanInput) {
+	if f == nil {
+		return
+	}
+	params.FilterExpression = aws.String(f.expression)
+	params.ExpressionAttributeNames = f.names
+	params.ExpressionAttributeValues = f.values
+}
+
+// incrementalBackup is a backup of the items changed since a previous backup.
+// With -incremental-diff, the differences are found by diff and info is nil
+// when there is no previous backup to compare with.
+type incrementalBackup struct {
+	filter *scanFilter
+	diff   *keyDiff
+	info   *storage.Incremental
+}
+
+// changedSince returns the filter of the items whose given attribute, in the
+// given format, holds a time at or after since
+func changedSince(attribute, format string, since time.Time) (*scanFilter, error) {
+	value := &dynamodb.AttributeValue{}
+	switch format {
+	case incrementalRFC3339:
+		value.S = aws.String(since.UTC().Format(time.RFC3339))
+	case incrementalEpoch:
+		value.N = aws.String(strconv.FormatInt(since.Unix(), 10))
+	case incrementalEpochMs:
+		value.N = aws.String(strconv.FormatInt(since.UnixNano()/int64(time.Millisecond), 10))
+	default:
+		return nil, fmt.Errorf("unknown format %q of the incremental attribute, expecting '%s', '%s' or '%s'", format, incrementalRFC3339, incrementalEpoch, incrementalEpochMs)
+	}
+	return &scanFilter{
+		expression: "#changed >= :since",
+		names:      map[string]*string{"#changed": aws.String(attribute)},
+		values:     map[string]*dynamodb.AttributeValue{":since": value},
+	}, nil
+}
+
+// newIncrementalBackup returns the incremental backup described by the given
+// settings, to be made in a date folder of the given folder, or nil for a
+// full backup. It is based on the latest complete backup of the date folders,
+// made at or before the time given by -since. With -since last, it holds the
+// items changed since this backup started: its start is used rather than its
+// end so that the items changed during its scan are not missed.
+func newIncrementalBackup(settings incrementalSettings, store storage.BackupIface, folder *storage.FileInput) (*incrementalBackup, error) {
+	if settings.diff {
+		return newKeyDiffBackup(store, folder)
+	}
+	if settings.attribute == "" {
+		return nil, nil
+	}
+	incremental := &incrementalBackup{info: &storage.Incremental{Attribute: settings.attribute}}
+	var since time.Time
+	if settings.since != "last" {
+		var err error
+		if since, err = parseTimestamp(settings.since); err != nil {
+			return nil, err
+		}
+	}
+	parent, err := store.FindBackup(folder, since)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the backup the incremental backup is based on: %s", err)
+	}
+	manifest, err := store.ReadManifest(parent.Folder)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the manifest of the previous backup: %s", err)
+	}
+	start := parent.Time
+	if manifest != nil && manifest.Start != nil {
+		start = *manifest.Start
+	}
+	info, err := store.Describe(parent.Folder)
+	if err != nil {
+		return nil, err
+	}
+	incremental.info.Parent, incremental.info.Since = info.URL, since
+	if settings.since == "last" {
+		incremental.info.Since = start
+	} else if start.Before(since) {
+		log.Printf("[WARNING] The items changed between %s and %s are neither in the backup %s nor in this one\n", start.Format(time.RFC3339), since.Format(time.RFC3339), info.URL)
+	}
+	log.Printf("Backing up the items changed since %s on top of the backup %s\n", incremental.info.Since.Format(time.RFC3339), info.URL)
+	filter, err := changedSince(settings.attribute, settings.format, incremental.info.Since)
+	if err != nil {
+		return nil, err
+	}
+	incremental.filter = filter
+	return incremental, nil
+}
+
+// backupChain returns the URLs of the folders of the backups to restore in
+// order to restore the backup of the given URL: the full backup it is based
+// on, followed by the incremental backups of its chain, oldest first. The
+// backups are opened using cfg.
+func backupChain(location string, cfg storage.Config) ([]string, error) {
+	chain := []string{location}
+	seen := map[string]bool{}
+	for !seen[location] {
+		seen[location] = true
+		store, folder, err := storage.Open(location, &cfg)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := store.ReadManifest(folder)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the manifest of %s: %s", location, err)
+		}
+		// The backup to restore itself may have no manifest with -restore-force
+		if manifest == nil && len(chain) > 1 {
+			return nil, fmt.Errorf("the parent backup %s is missing", location)
+		}
+		if manifest == nil || manifest.Incremental == nil {
+			return chain, nil
+		}
+		if manifest.Incremental.Parent == "" {
+			return nil, fmt.Errorf("the incremental backup %s does not record the backup it is based on", location)
+		}
+		location = manifest.Incremental.Parent
+		chain = append([]string{location}, chain...)
+	}
+	return nil, fmt.Errorf("the backup %s is part of a loop of incremental backups", location)
+}
+
+// restoreChain restores the backups of the given chain, as returned by
+// backupChain, in order: the incremental backups are written on top of the
+// full backup they are based on. The state of the restore of each backup is
+// kept, in its folder or in the stateFile suffixed by its position in the
+// chain, until the whole chain is restored so that a resumed restore skips
+// the backups already restored.
+func restoreChain(chain []string, cfg storage.Config, tableName string, batchSize int64, waitPeriod time.Duration, workers int, appendToTable, createTable, resume, force bool, stateFile string, settings throughputSettings) {
+	savers := []*restoreStateSaver{}
+	for idx, location := range chain {
+		c = make(chan map[string]*dynamodb.AttributeValue)
+		cfg.DataPipe = c
+		store, folder, err := storage.Open(location, &cfg)
+		if err != nil {
+			log.Fatalf("[ERROR] Unable to use the storage: %s\n", err)
+		}
+		linkStateFile := stateFile
+		if stateFile != "" {
+			linkStateFile = fmt.Sprintf("%s.%d", stateFile, idx)
+		}
+		log.Printf("Restoring the backup %s (%d/%d)\n", location, idx+1, len(chain))
+		last := idx == len(chain)-1
+		restoreTable(*folder.Bucket, *folder.Path, tableName, batchSize, waitPeriod, workers, appendToTable || idx > 0, createTable && idx == 0, resume, force, linkStateFile, !last, settings, store)
+		savers = append(savers, newRestoreStateSaver(store, folder, tableName, linkStateFile))
+	}
+	for _, saver := range savers[:len(savers)-1] {
+		if err := saver.clear(); err != nil {
+			log.Printf("[WARNING] Unable to remove the state of the restore: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// mockFilterDynamoDBClient records the filter expressions of the scans
+type mockFilterDynamoDBClient struct {
+	mockDynamoDBClient
+	filters []string
+}
+
+func (m *mockFilterDynamoDBClient) ScanPages(params *dynamodb.ScanInput, pager func(*dynamodb.ScanOutput, bool) bool) error {
+	m.mu.Lock()
+	m.filters = append(m.filters, aws.StringValue(params.FilterExpression)+" "+aws.StringValue(params.ExpressionAttributeNames["#changed"]))
+	m.mu.Unlock()
+	return m.mockDynamoDBClient.ScanPages(params, pager)
+}
+
+func TestChangedSince(t *testing.T) {
+	since := time.Date(2019, 1, 1, 2, 0, 0, 500000000, time.UTC)
+	testCases := []struct {
+		format   string
+		expected *dynamodb.AttributeValue
+	}{
+		{format: incrementalRFC3339, expected: &dynamodb.AttributeValue{S: aws.String("2019-01-01T02:00:00Z")}},
+		{format: incrementalEpoch, expected: &dynamodb.AttributeValue{N: aws.String("1546308000")}},
+		{format: incrementalEpochMs, expected: &dynamodb.AttributeValue{N: aws.String("1546308000500")}},
+	}
+	for _, tc := range testCases {
+		filter, err := changedSince("updated_at", tc.format, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(filter.values[":since"], tc.expected) || *filter.names["#changed"] != "updated_at" {
+			t.Errorf("Format %s: unexpected filter %+v", tc.format, filter)
+		}
+	}
+	if _, err := changedSince("updated_at", "date", since); err == nil {
+		t.Error("Expecting an error for an unknown format")
+	}
+
+	svc := &mockFilterDynamoDBClient{}
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	filter, _ := changedSince("updated_at", incrementalRFC3339, since)
+	go func() {
+		for range dataPipe {
+		}
+	}()
+	if err := ParallelTableToChannel(svc, "myTable", 10, 0, 2, nil, nil, filter, dataPipe); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.filters) != 2 || svc.filters[0] != "#changed >= :since updated_at" || svc.filters[1] != svc.filters[0] {
+		t.Errorf("Expecting every segment to be scanned with the filter, got %v", svc.filters)
+	}
+}
+
+func TestIncrementalBackup(t *testing.T) {
+	base := "mem://tests/incremental/myTable/2019-01-01-02-00-00"
+	memBackup(t, base)
+	store, folder, err := storage.Open("mem://tests/incremental/myTable", &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := store.ReadManifest(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String(*folder.Path + "/2019-01-01-02-00-00")})
+	if err != nil || parent == nil || parent.Start == nil || parent.End == nil {
+		t.Fatalf("Expecting the manifest to record the start and end of the backup, got %+v, %v", parent, err)
+	}
+
+	incremental, err := newIncrementalBackup(incrementalSettings{attribute: "updated_at", format: incrementalRFC3339, since: "last"}, store, folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := storage.Incremental{Parent: base, Attribute: "updated_at", Since: *parent.Start}
+	if !reflect.DeepEqual(*incremental.info, expected) || incremental.filter == nil {
+		t.Fatalf("Expecting %+v, got %+v", expected, incremental.info)
+	}
+	if incremental, err = newIncrementalBackup(incrementalSettings{}, store, folder); incremental != nil || err != nil {
+		t.Errorf("Expecting no incremental backup without attribute, got %+v, %v", incremental, err)
+	}
+
+	// Writes an incremental backup on top of the base one
+	var wg sync.WaitGroup
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	location := "mem://tests/incremental/myTable/2019-01-02-02-00-00"
+	store, folder, err = storage.Open(location, &storage.Config{DataPipe: dataPipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(1)
+	go store.Write(folder, 1024, &wg)
+	dataPipe <- dataSet[0]
+	close(dataPipe)
+	wg.Wait()
+	store.SetManifestInfo(storage.ManifestInfo{Incremental: &expected})
+	if err = store.Commit(folder, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := backupChain(location, storage.Config{})
+	if err != nil || !reflect.DeepEqual(chain, []string{base, location}) {
+		t.Errorf("Expecting the chain %v, got %v, %v", []string{base, location}, chain, err)
+	}
+	if chain, err = backupChain(base, storage.Config{}); err != nil || !reflect.DeepEqual(chain, []string{base}) {
+		t.Errorf("Expecting a full backup to be restored on its own, got %v, %v", chain, err)
+	}
+
+	// The parent of an incremental backup is gone
+	orphan := "mem://tests/incremental/myTable/2019-01-03-02-00-00"
+	dataPipe = make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err = storage.Open(orphan, &storage.Config{DataPipe: dataPipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(1)
+	go store.Write(folder, 1024, &wg)
+	close(dataPipe)
+	wg.Wait()
+	store.SetManifestInfo(storage.ManifestInfo{Incremental: &storage.Incremental{Parent: "mem://tests/incremental/myTable/2018-12-31-02-00-00", Attribute: "updated_at"}})
+	if err = store.Commit(folder, nil); err != nil {
+		t.Fatal(err)
+	}
+	if chain, err = backupChain(orphan, storage.Config{}); err == nil || !strings.Contains(err.Error(), "parent backup") {
+		t.Errorf("Expecting an error for the missing parent, got %v, %v", chain, err)
+	}
+}
+
+// mockRestoreDynamoDBClient records the items restored into an empty table
+type mockRestoreDynamoDBClient struct {
+	mockDynamoDBClient
+}
+
+func (m *mockRestoreDynamoDBClient) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	return &dynamodb.DescribeTableOutput{Table: &dynamodb.TableDescription{TableName: input.TableName, TableStatus: aws.String("ACTIVE"), ItemCount: aws.Int64(0)}}, nil
+}
+
+func TestIncrementalBackupSince(t *testing.T) {
+	base := "mem://tests/since/myTable/2019-01-01-02-00-00"
+	memBackup(t, base)
+	location := "mem://tests/since/myTable"
+	c := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := storage.Open(location, &storage.Config{DataPipe: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := incrementalSettings{attribute: "updated_at", format: incrementalRFC3339, since: "2018-12-31T02:00:00Z"}
+	if _, err = newIncrementalBackup(settings, store, folder); err == nil {
+		t.Errorf("Expecting an error without any backup made before -since")
+	}
+	settings.since = "2019-01-01T03:00:00Z"
+	incremental, err := newIncrementalBackup(settings, store, folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incremental.info.Parent != base || !incremental.info.Since.Equal(time.Date(2019, 1, 1, 3, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expecting the backup to be based on %s, got %+v", base, incremental.info)
+	}
+
+	queen := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Queen")}, "songs": {SS: aws.StringSlice([]string{"Bohemian Rhapsody"})}}
+	dynamoSvc = &mockDiffDynamoDBClient{items: []map[string]*dynamodb.AttributeValue{queen}}
+	if err = backupTable("myTable", 10, 0, 1, *folder.Bucket, *folder.Path+"/2019-01-02-02-00-00", false, false, throughputSettings{}, incremental, newScanTracker(), c, store); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := backupChain(location+"/2019-01-02-02-00-00", storage.Config{})
+	if err != nil || len(chain) != 2 || chain[0] != base {
+		t.Fatalf("Expecting the chain to start with %s, got %v, %v", base, chain, err)
+	}
+
+	svc := &mockRestoreDynamoDBClient{}
+	dynamoSvc = svc
+	restoreChain(chain, storage.Config{}, "myTable", 25, 0, 1, false, false, false, false, "", throughputSettings{})
+	if len(svc.written) != len(dataSet)+1 || !reflect.DeepEqual(svc.written[len(dataSet)], queen) {
+		t.Errorf("Expecting the full backup then the incremental one to be restored, got %v", svc.written)
+	}
+
+	// A resumed restore skips the backups of the chain already restored
+	dir, err := ioutil.TempDir("", "dynamodbdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state")
+	if err = ioutil.WriteFile(stateFile+".0", []byte(`{"tableName":"myTable","completed":[],"done":true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	svc = &mockRestoreDynamoDBClient{}
+	dynamoSvc = svc
+	restoreChain(chain, storage.Config{}, "myTable", 25, 0, 1, false, false, true, false, stateFile, throughputSettings{})
+	if !reflect.DeepEqual(svc.written, []map[string]*dynamodb.AttributeValue{queen}) {
+		t.Errorf("Expecting only the incremental backup to be restored, got %v", svc.written)
+	}
+	if files, _ := filepath.Glob(stateFile + "*"); len(files) != 0 {
+		t.Errorf("Expecting the states to be removed once the chain is restored, got %v", files)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// backupDetails is what -action inspect shows of a backup
+type backupDetails struct {
+	*storage.BackupInfo
+	Manifest *storage.Manifest `json:"manifest,omitempty"`
+	Schema   *TableSchema      `json:"schema,omitempty"`
+	Failure  *storage.Failure  `json:"failure,omitempty"`
+}
+
+// writeJSON writes the given value as indented json
+func writeJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// formatSize returns a human readable version of the given number of bytes,
+// or "-" if unknown
+func formatSize(size *int64) string {
+	if size == nil {
+		return "-"
+	}
+	value := float64(*size)
+	for _, unit := range []string{"B", "KB", "MB", "GB", "TB"} {
+		if value < 1024 || unit == "TB" {
+			if unit == "B" {
+				return fmt.Sprintf("%d B", *size)
+			}
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+		value /= 1024
+	}
+	return ""
+}
+
+// formatCount returns the given count or "-" if unknown
+func formatCount(count *int64) string {
+	if count == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d", *count)
+}
+
+// formatTime returns the given time or "-" if unknown
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// listBackups writes the list of the backups found in the given folder and
+// its date folders, either as a table or as json
+func listBackups(folder *storage.FileInput, store storage.BackupIface, output string, w io.Writer) error {
+	backups, err := store.ListBackups(folder)
+	if err != nil {
+		return err
+	}
+	if output == "json" {
+		return writeJSON(w, backups)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tSTATUS\tFILES\tSIZE\tITEMS\tURL")
+	for _, backup := range backups {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", formatTime(backup.Time), backup.Status, backup.Files, formatSize(backup.Size), formatCount(backup.Items), backup.URL)
+	}
+	return tw.Flush()
+}
+
+// inspectBackup writes the stats, the manifest, the schema and the failure
+// details of the backup of the given folder, either as text or as json
+func inspectBackup(folder *storage.FileInput, store storage.BackupIface, output string, w io.Writer) error {
+	info, err := store.Describe(folder)
+	if err != nil {
+		return err
+	}
+	details := backupDetails{BackupInfo: info}
+	if details.Manifest, err = store.ReadManifest(folder); err != nil {
+		return fmt.Errorf("unable to read the manifest: %s", err)
+	}
+	if details.Failure, err = store.LoadFailure(folder); err != nil {
+		return fmt.Errorf("unable to read the failure details: %s", err)
+	}
+	schemaFile := &storage.FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *folder.Path, schemaFileName))}
+	if exists, err := store.Exists(schemaFile); err != nil {
+		return err
+	} else if exists {
+		if details.Schema, err = loadSchema(folder, store); err != nil {
+			return fmt.Errorf("unable to read the schema: %s", err)
+		}
+	}
+	if output == "json" {
+		return writeJSON(w, details)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "URL:\t%s\n", info.URL)
+	fmt.Fprintf(tw, "Status:\t%s\n", info.Status)
+	fmt.Fprintf(tw, "Files:\t%d\n", info.Files)
+	fmt.Fprintf(tw, "Size:\t%s\n", formatSize(info.Size))
+	fmt.Fprintf(tw, "Items:\t%s\n", formatCount(info.Items))
+	if details.Failure != nil {
+		fmt.Fprintf(tw, "Failure:\t%s at %s\n", details.Failure.Error, details.Failure.Time)
+	}
+	if manifest := details.Manifest; manifest != nil && manifest.Compression != "" {
+		fmt.Fprintf(tw, "Compression:\t%s\n", manifest.Compression)
+	}
+	if schema := details.Schema; schema != nil {
+		tbl := schema.Table
+		keys := []string{}
+		for _, key := range tbl.KeySchema {
+			keys = append(keys, fmt.Sprintf("%s (%s)", aws.StringValue(key.AttributeName), aws.StringValue(key.KeyType)))
+		}
+		fmt.Fprintf(tw, "Table:\t%s\n", aws.StringValue(tbl.TableName))
+		fmt.Fprintf(tw, "Keys:\t%s\n", strings.Join(keys, ", "))
+		if tbl.BillingModeSummary != nil && tbl.BillingModeSummary.BillingMode != nil {
+			fmt.Fprintf(tw, "Billing mode:\t%s\n", *tbl.BillingModeSummary.BillingMode)
+		}
+		if tbl.ProvisionedThroughput != nil {
+			fmt.Fprintf(tw, "Capacity:\t%d read, %d write\n", aws.Int64Value(tbl.ProvisionedThroughput.ReadCapacityUnits), aws.Int64Value(tbl.ProvisionedThroughput.WriteCapacityUnits))
+		}
+		fmt.Fprintf(tw, "Indexes:\t%d global, %d local\n", len(tbl.GlobalSecondaryIndexes), len(tbl.LocalSecondaryIndexes))
+		if ttl := schema.TimeToLive; ttl != nil && ttl.AttributeName != nil {
+			fmt.Fprintf(tw, "TTL:\t%s (%s)\n", *ttl.AttributeName, aws.StringValue(ttl.TimeToLiveStatus))
+		}
+		fmt.Fprintf(tw, "Tags:\t%d\n", len(schema.Tags))
+	}
+	if err = tw.Flush(); err != nil {
+		return err
+	}
+
+	if details.Manifest != nil && len(details.Manifest.Entries) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "FILE\tSIZE\tITEMS\tSHA256")
+		for _, entry := range details.Manifest.Entries {
+			size, items, checksum := aws.Int64(entry.Size), aws.Int64(entry.Items), entry.SHA256
+			if entry.SHA256 == "" {
+				size, items, checksum = nil, nil, "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", entry.URL, formatSize(size), formatCount(items), checksum)
+		}
+		return tw.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// memBackup writes a backup of the data set with the schema of the test table
+// in the given mem:// folder
+func memBackup(t *testing.T, location string) (storage.BackupIface, *storage.FileInput) {
+	var wg sync.WaitGroup
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := storage.Open(location, &storage.Config{DataPipe: dataPipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = backupSchema(&mockSchemaDynamoDBClient{}, "myTable", folder, store); err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(1)
+	go store.Write(folder, 1024, &wg)
+	for _, item := range dataSet {
+		dataPipe <- item
+	}
+	close(dataPipe)
+	wg.Wait()
+	if err = store.Commit(folder, nil); err != nil {
+		t.Fatal(err)
+	}
+	return store, folder
+}
+
+func TestListBackups(t *testing.T) {
+	store, _ := memBackup(t, "mem://tests/list/myTable/2019-01-01-02-00-00")
+	folder := &storage.FileInput{Bucket: aws.String("tests"), Path: aws.String("list/myTable")}
+
+	var out bytes.Buffer
+	if err := listBackups(folder, store, "text", &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "DATE") || !strings.Contains(lines[1], "2019-01-01T02:00:00Z  complete  1 ") || !strings.HasSuffix(lines[1], "mem://tests/list/myTable/2019-01-01-02-00-00") {
+		t.Errorf("Unexpected list:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := listBackups(folder, store, "json", &out); err != nil {
+		t.Fatal(err)
+	}
+	backups := []storage.BackupInfo{}
+	if err := json.Unmarshal(out.Bytes(), &backups); err != nil {
+		t.Fatalf("Invalid json output: %s\n%s", err, out.String())
+	}
+	if len(backups) != 1 || aws.Int64Value(backups[0].Items) != int64(len(dataSet)) {
+		t.Errorf("Unexpected backups: %+v", backups)
+	}
+}
+
+func TestInspectBackup(t *testing.T) {
+	store, folder := memBackup(t, "mem://tests/inspect/myTable")
+
+	var out bytes.Buffer
+	if err := inspectBackup(folder, store, "text", &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Status:    complete", "Items:     3", "Table:     myTable", "Keys:      artist (HASH)", "Indexes:   1 global, 0 local", "TTL:       expires (ENABLED)", "FILE"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expecting %q in:\n%s", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := inspectBackup(folder, store, "json", &out); err != nil {
+		t.Fatal(err)
+	}
+	details := struct {
+		Status   string            `json:"status"`
+		Manifest *storage.Manifest `json:"manifest"`
+		Schema   *TableSchema      `json:"schema"`
+	}{}
+	if err := json.Unmarshal(out.Bytes(), &details); err != nil {
+		t.Fatalf("Invalid json output: %s\n%s", err, out.String())
+	}
+	if details.Status != storage.StatusComplete || details.Manifest == nil || len(details.Manifest.Entries) != 1 || details.Schema == nil {
+		t.Errorf("Unexpected details: %s", out.String())
+	}
+}
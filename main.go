@@ -4,6 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +16,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
 	"github.com/gobike/envflag"
 )
 
@@ -21,26 +26,130 @@ var (
 )
 
 // backupTable manages the consumer from a given DynamoDB table and a producer
-// to a given s3 bucket
-func backupTable(tableName string, batchSize int64, waitPeriod time.Duration, bucket, prefix string, addDate bool, store storage.BackupIface) {
+// to a given s3 bucket. When resuming, the backup continues from the checkpoint
+// found in the folder if any. The items are sent to the store through the given
+// dataPipe, which has to be the DataPipe of the store. For an incremental
+// backup, only the changed items are backed up.
+func backupTable(tableName string, batchSize int64, waitPeriod time.Duration, scanSegments int64, bucket, prefix string, addDate, resume bool, settings throughputSettings, incremental *incrementalBackup, tracker *scanTracker, dataPipe chan map[string]*dynamodb.AttributeValue, store storage.BackupIface) error {
 	var wg sync.WaitGroup
 	if addDate {
 		t := time.Now().UTC()
-		prefix += "/" + t.Format("2006-01-02-15-04-05")
+		prefix += "/" + t.Format(storage.DateFolderFormat)
 	}
 
-	wg.Add(1)
-	go store.Write(&storage.FileInput{Bucket: aws.String(bucket), Path: aws.String(prefix)}, 10*1024*1024, &wg)
+	folder := &storage.FileInput{Bucket: aws.String(bucket), Path: aws.String(prefix)}
+	var positions []storage.SegmentPosition
+	var written int64
+	if resume {
+		if exists, err := store.Exists(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/_SUCCESS", prefix))}); err != nil || exists {
+			if err != nil {
+				return fmt.Errorf("unable to retrieve the _SUCCESS flag information: %s", err)
+			}
+			log.Println("The backup is already complete, nothing to resume.")
+			return nil
+		}
+		checkpoint, err := store.LoadCheckpoint(folder)
+		if err != nil {
+			return fmt.Errorf("unable to load the checkpoint: %s", err)
+		}
+		if checkpoint != nil {
+			log.Printf("Resuming the backup after %d items\n", checkpoint.Items)
+			positions = checkpoint.Segments
+			written = checkpoint.Items
+			if int64(len(positions)) != scanSegments {
+				log.Printf("[WARNING] The interrupted backup used %d segments, using the same number\n", len(positions))
+				scanSegments = int64(len(positions))
+			}
+		} else {
+			log.Println("No checkpoint found, starting the backup from the beginning.")
+		}
+	}
+	if scanSegments < 1 {
+		scanSegments = 1
+	}
+	tracker.reset(scanSegments, written, positions)
+
+	if err := backupSchema(dynamoSvc, tableName, folder, store); err != nil {
+		return fmt.Errorf("unable to backup the schema of the table: %s", err)
+	}
 
-	err := TableToChannel(dynamoSvc, tableName, batchSize, waitPeriod, c)
+	throughput, err := settings.controller(dynamoSvc, tableName, false)
 	if err != nil {
-		log.Fatal(err.Error())
+		return fmt.Errorf("unable to set up the throughput control: %s", err)
+	}
+
+	if incremental != nil && incremental.diff != nil {
+		if incremental.diff.keys, err = tableKeys(dynamoSvc, tableName); err != nil {
+			return fmt.Errorf("unable to retrieve the keys of the table: %s", err)
+		}
+	}
+
+	wg.Add(1)
+	writeErrs := make(chan error, 1)
+	go func() {
+		writeErrs <- store.Write(folder, 10*1024*1024, &wg)
+	}()
+
+	var filter *scanFilter
+	scanned := dataPipe
+	diffErrs := make(chan error, 1)
+	if incremental != nil && incremental.diff != nil {
+		// The scanned items go through the diff, which sends the differences
+		// to dataPipe
+		scanned = make(chan map[string]*dynamodb.AttributeValue)
+		go func() {
+			diffErrs <- incremental.diff.run(scanned, dataPipe, store, folder)
+		}()
+	} else {
+		diffErrs <- nil
+	}
+	if incremental != nil {
+		filter = incremental.filter
+	}
+	failure := ParallelTableToChannel(dynamoSvc, tableName, batchSize, waitPeriod, scanSegments, tracker, throughput, filter, scanned)
+	if err = <-diffErrs; failure == nil {
+		failure = err
 	}
 	wg.Wait()
+	if err = <-writeErrs; failure == nil {
+		failure = err
+	}
+	if incremental != nil {
+		store.SetManifestInfo(storage.ManifestInfo{Incremental: incremental.info})
+	}
+	// Only flags the backup as successful if everything has been written
+	return store.Commit(folder, failure)
 }
 
-func restoreTable(bucket, prefix, tableName string, batchSize int64, waitPeriod time.Duration, appendToTable bool, store storage.BackupIface) {
+// restoreTable restores the backup of the given folder into the given table.
+// The progress of the restore is saved either in the stateFile or in the backup
+// folder so that it can be resumed. With keepState, the state is kept once the
+// restore is complete so that a resumed restore skips it. When forced, the
+// backup is restored even if it is not complete, using the data files found in
+// the folder if it has no manifest.
+func restoreTable(bucket, prefix, tableName string, batchSize int64, waitPeriod time.Duration, workers int, appendToTable, createTable, resume, force bool, stateFile string, keepState bool, settings throughputSettings, store storage.BackupIface) {
 	var wg sync.WaitGroup
+	folder := &storage.FileInput{Bucket: aws.String(bucket), Path: aws.String(prefix)}
+	stateSaver := newRestoreStateSaver(store, folder, tableName, stateFile)
+	if resume {
+		state, err := stateSaver.load()
+		if err != nil {
+			log.Fatalf("[ERROR] Unable to load the state of the restore: %s\nAborting...\n", err)
+		}
+		if state != nil && state.Done {
+			log.Println("The backup is already restored, nothing to resume.")
+			return
+		}
+		if state != nil {
+			log.Printf("Resuming the restore after %d completed files\n", len(state.Completed))
+			store.ResumeRestore(state)
+			// The table is expected to hold the data of the previous run
+			appendToTable = true
+		} else {
+			log.Println("No restore state found, starting the restore from the beginning.")
+		}
+	}
+
 	// Check if the table exists and has data in it. If so, abort
 	itemsCount, err := CheckTableEmpty(dynamoSvc, tableName)
 	if err != nil {
@@ -49,8 +158,8 @@ func restoreTable(bucket, prefix, tableName string, batchSize int64, waitPeriod
 	switch {
 	case itemsCount > 0 && !appendToTable:
 		log.Fatalf("[ERROR] The target table is not empty. Aborting...\n")
-	case itemsCount == -1:
-		log.Fatalf("[ERROR] The target table does not exists. Aborting...\n")
+	case itemsCount == -1 && !createTable:
+		log.Fatalf("[ERROR] The target table does not exists. Use -restore-create-table to create it from the backup. Aborting...\n")
 	case itemsCount < -1:
 		log.Fatalf("[ERROR] The target table is not in ACTIVE state, so not writable. Aborting...\n")
 	}
@@ -60,59 +169,325 @@ func restoreTable(bucket, prefix, tableName string, batchSize int64, waitPeriod
 		switch {
 		case err != nil:
 			log.Fatalf("[ERROR] Unable to retrieve the _SUCCESS flag information: %s\nAborting...\n", err)
-		case !exists:
+		case force:
+			log.Println("[WARNING] No _SUCCESS flag found in the provided folder, restoring it anyway.")
+		default:
+			if failure, _ := store.LoadFailure(folder); failure != nil {
+				log.Fatalf("[ERROR] The backup failed on %s after writing %d items: %s\nAborting...\n", failure.Time, failure.Items, failure.Error)
+			}
 			log.Fatalf("[ERROR] Unable to find a _SUCCESS flag in the provided folder. Are you sure the backup was successful?\nAborting...\n")
 		}
 	}
 
 	// Pull the manifest from s3 and load it to memory
-	err = store.LoadManifest(&storage.FileInput{Bucket: aws.String(bucket), Path: aws.String(fmt.Sprintf("%s/_SUCCESS", prefix))})
+	manifest := &storage.FileInput{Bucket: aws.String(bucket), Path: aws.String(fmt.Sprintf("%s/manifest", prefix))}
+	if exists, _ := store.Exists(manifest); !exists && force {
+		log.Println("[WARNING] No manifest found in the provided folder, restoring all the data files it holds.")
+		_, err = store.RebuildManifest(folder, false)
+	} else {
+		err = store.LoadManifest(manifest)
+	}
 	if err != nil {
 		log.Fatalf("[ERROR] Unable to load the manifest flag information: %s\nAborting...\n", err)
 	}
 
+	// Create the table from the schema saved with the backup
+	if itemsCount == -1 {
+		schema, err := loadSchema(folder, store)
+		if err != nil {
+			log.Fatalf("[ERROR] Unable to load the schema of the backup: %s\nAborting...\n", err)
+		}
+		log.Printf("Creating the table %s from the schema of the backup\n", tableName)
+		if err = CreateTableFromSchema(dynamoSvc, tableName, schema); err != nil {
+			log.Fatalf("[ERROR] Unable to create the table: %s\nAborting...\n", err)
+		}
+	}
+
+	throughput, err := settings.controller(dynamoSvc, tableName, true)
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to set up the throughput control: %s\nAborting...\n", err)
+	}
+
 	// For each file in the manifest pull the file, decode each line and add them to a batch and push them into the table (batch size, then wait and continue)
-	go ChannelToTable(dynamoSvc, tableName, batchSize, waitPeriod, c, &wg)
+	writeErrs := make(chan error, 1)
+	go func() {
+		writeErrs <- ChannelToTable(dynamoSvc, tableName, batchSize, waitPeriod, workers, throughput, c, stateSaver.ack, &wg)
+	}()
 	err = store.WriteToDB(tableName, batchSize, waitPeriod, &wg)
 	if err != nil {
 		log.Fatalf("[ERROR] Unable to import the full s3 backup to Dynamo: %s\nAborting...\n", err)
 	}
 	wg.Wait()
+	if err = <-writeErrs; err != nil {
+		if saveErr := stateSaver.save(); saveErr != nil {
+			log.Printf("[WARNING] Unable to save the progress of the restore: %s\n", saveErr)
+		}
+		log.Fatalf("[ERROR] Unable to write the backup to the table: %s\nAborting...\n", err)
+	}
+	if keepState {
+		err = stateSaver.finish()
+	} else {
+		err = stateSaver.clear()
+	}
+	if err != nil {
+		log.Printf("[WARNING] Unable to update the state of the restore: %s\n", err)
+	}
+}
+
+// findBackup returns the newest complete backup of the date folders of the
+// given folder made at or before asOf, if given
+func findBackup(folder *storage.FileInput, asOf string, store storage.BackupIface) *storage.DatedBackup {
+	var limit time.Time
+	if asOf != "" {
+		t, err := parseTimestamp(asOf)
+		if err != nil {
+			log.Fatalf("[ERROR] %s\n", err)
+		}
+		limit = t
+	}
+	backup, err := store.FindBackup(folder, limit)
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to find the backup to restore: %s\nAborting...\n", err)
+	}
+	log.Printf("Restoring the backup made on %s from %s\n", backup.Time.Format(time.RFC3339), *backup.Folder.Path)
+	return backup
+}
+
+// repairBackup rebuilds the manifest of the backup of the given folder from
+// the data files it holds and exits with an error if any of them is invalid
+func repairBackup(folder *storage.FileInput, complete bool, store storage.BackupIface) {
+	report, err := store.Repair(folder, complete)
+	if report != nil {
+		for _, entry := range report.Entries {
+			for _, problem := range entry.Errors {
+				log.Printf("[ERROR] %s: %s, left out of the manifest\n", entry.URL, problem)
+			}
+		}
+		for _, problem := range report.Errors {
+			log.Printf("[ERROR] %s\n", problem)
+		}
+	}
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to repair the backup: %s\nAborting...\n", err)
+	}
+	if !report.OK() {
+		log.Fatalf("[ERROR] The manifest has been rebuilt without the invalid data files.\n")
+	}
+	log.Printf("Rebuilt the manifest of %d data files\n", len(report.Entries))
+}
+
+// verifyBackup checks the files of the backup of the given folder against its
+// manifest and exits with an error if any problem is found
+func verifyBackup(folder *storage.FileInput, store storage.BackupIface) {
+	report, err := store.Verify(folder)
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to load the manifest of the backup: %s\nAborting...\n", err)
+	}
+	var items int64
+	for _, entry := range report.Entries {
+		items += entry.Items
+		for _, problem := range entry.Errors {
+			log.Printf("[ERROR] %s: %s\n", entry.URL, problem)
+		}
+	}
+	for _, problem := range report.Errors {
+		log.Printf("[ERROR] %s\n", problem)
+	}
+	if !report.OK() {
+		log.Fatalf("[ERROR] The backup is corrupted.\n")
+	}
+	log.Printf("Verified %d files holding %d items\n", len(report.Entries), items)
+}
+
+// parseTimestamp parses a timestamp given either in the RFC 3339 format or in
+// the format of the date folders, in UTC
+func parseTimestamp(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(storage.DateFolderFormat, value)
+	if err != nil {
+		return t, fmt.Errorf("invalid timestamp %q, expecting the RFC 3339 format (2006-01-02T15:04:05Z) or %s", value, storage.DateFolderFormat)
+	}
+	return t, nil
+}
+
+// storageURL returns the URL of the backup folder. If no URL is given, it is
+// built from the legacy -s3-bucket, -s3-folder and -local-dir flags.
+func storageURL(location, bucket, folder, localDir string) (string, error) {
+	switch {
+	case location != "":
+		return location, nil
+	case localDir != "":
+		dir, err := filepath.Abs(filepath.Join(localDir, folder))
+		if err != nil {
+			return "", err
+		}
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir)}).String(), nil
+	case bucket != "":
+		return (&url.URL{Scheme: "s3", Host: bucket, Path: "/" + strings.TrimPrefix(folder, "/")}).String(), nil
+	default:
+		return "", fmt.Errorf("no storage provided. Please set -target (or -source for a restore), -s3-bucket or -local-dir")
+	}
+}
+
+// run runs the job described by the given validated options
+func run(o *options, awsSess *session.Session) {
+	retry = o.retryPolicy()
+	dynamoSvc = dynamodb.New(awsSess, o.dynamoConfig())
+	c = make(chan map[string]*dynamodb.AttributeValue)
+	waitPeriod := time.Duration(o.waitTime) * time.Millisecond
+	tracker := newScanTracker()
+	storageConfig := storage.Config{Session: awsSess, S3Config: o.s3Config(), DataPipe: c, Compression: o.compression, Checkpointer: tracker.positions, Staging: o.staging}
+	bkpStorage, folder, err := storage.Open(o.location, &storageConfig)
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to use the storage: %s\n", err)
+	}
+
+	switch o.action {
+	case "backup":
+		if o.tableSelection == "" && o.tableTagFilters == "" {
+			incremental, err := newIncrementalBackup(o.incremental, bkpStorage, folder)
+			if err != nil {
+				log.Fatalf("[ERROR] Unable to set up the incremental backup: %s\nAborting...\n", err)
+			}
+			if err = backupTable(o.tableName, o.batchSize, waitPeriod, o.scanSegments, *folder.Bucket, *folder.Path, o.s3DateSuffix, o.resumeBackup, o.throughput, incremental, tracker, c, bkpStorage); err != nil {
+				log.Fatalf("[ERROR] The backup failed: %s\nAborting...\n", err)
+			}
+			break
+		}
+		backups, err := tableBackups(dynamoSvc, o.tableSelection, o.tableTagFilters, tableBackup{BatchSize: o.batchSize, WaitPeriod: waitPeriod, ScanSegments: o.scanSegments})
+		if err != nil {
+			log.Fatalf("[ERROR] Unable to select the tables to backup: %s\nAborting...\n", err)
+		}
+		summary, err := backupTables(backups, o.tableWorkers, o.location, o.s3DateSuffix, o.resumeBackup, o.throughput, o.incremental, storageConfig, bkpStorage, folder)
+		if err != nil {
+			log.Printf("[ERROR] Unable to write the summary of the backups: %s\n", err)
+		}
+		if summary.Failed > 0 || err != nil {
+			log.Fatalf("[ERROR] The backup of %d out of %d tables failed.\nAborting...\n", summary.Failed, len(backups))
+		}
+	case "restore":
+		var backupTime time.Time
+		if o.restoreLatest || o.restoreAsOf != "" {
+			backup := findBackup(folder, o.restoreAsOf, bkpStorage)
+			folder, backupTime = backup.Folder, backup.Time
+			// The date folder of a compacted backup is named after the
+			// compaction while it holds the items as of its manifest start
+			if manifest, err := bkpStorage.ReadManifest(folder); err == nil && manifest != nil && manifest.Start != nil {
+				backupTime = *manifest.Start
+			}
+		}
+		info, err := bkpStorage.Describe(folder)
+		if err != nil {
+			log.Fatalf("[ERROR] Unable to read the backup: %s\nAborting...\n", err)
+		}
+		chain, err := backupChain(info.URL, storageConfig)
+		if err != nil {
+			log.Fatalf("[ERROR] Unable to find the backups the incremental backup is based on: %s\nAborting...\n", err)
+		}
+		if len(chain) > 1 {
+			restoreChain(chain, storageConfig, o.tableName, o.batchSize, waitPeriod, o.restoreWorkers, o.appendRestore, o.createRestore, o.resumeRestore, o.forceRestore, o.restoreStateFile, o.throughput)
+		} else {
+			restoreTable(*folder.Bucket, *folder.Path, o.tableName, o.batchSize, waitPeriod, o.restoreWorkers, o.appendRestore, o.createRestore, o.resumeRestore, o.forceRestore, o.restoreStateFile, false, o.throughput, bkpStorage)
+		}
+		if o.changesSource != "" {
+			var until time.Time
+			if o.restoreAsOf != "" {
+				until, _ = parseTimestamp(o.restoreAsOf)
+			}
+			if err = replayChanges(o.changesSource, storageConfig, o.tableName, backupTime, until, o.batchSize, waitPeriod, o.throughput); err != nil {
+				log.Fatalf("[ERROR] Unable to replay the archived changes: %s\nAborting...\n", err)
+			}
+		}
+	case "stream-archive":
+		if err = archiveStream(dynamodbstreams.New(awsSess, o.dynamoConfig()), o.tableName, o.location, storageConfig, time.Duration(o.streamSegmentMs)*time.Millisecond, time.Duration(o.streamPollMs)*time.Millisecond, o.streamOnce, bkpStorage, folder); err != nil {
+			log.Fatalf("[ERROR] The archiving of the stream failed: %s\nAborting...\n", err)
+		}
+	case "compact":
+		if o.restoreLatest || o.restoreAsOf != "" {
+			folder = findBackup(folder, o.restoreAsOf, bkpStorage).Folder
+		}
+		info, err := bkpStorage.Describe(folder)
+		if err != nil {
+			log.Fatalf("[ERROR] Unable to read the backup: %s\nAborting...\n", err)
+		}
+		targetConfig := storageConfig
+		targetConfig.Checkpointer = nil
+		target, targetFolder, err := storage.Open(o.target, &targetConfig)
+		if err != nil {
+			log.Fatalf("[ERROR] Unable to use the target storage: %s\n", err)
+		}
+		if o.s3DateSuffix {
+			targetFolder.Path = aws.String(*targetFolder.Path + "/" + time.Now().UTC().Format(storage.DateFolderFormat))
+		}
+		if err = compactBackup(info.URL, storageConfig, o.compactDir, c, target, targetFolder); err != nil {
+			log.Fatalf("[ERROR] The compaction failed: %s\nAborting...\n", err)
+		}
+	case "verify":
+		verifyBackup(folder, bkpStorage)
+	case "repair":
+		repairBackup(folder, o.repairSuccess, bkpStorage)
+	case "list":
+		if err = listBackups(folder, bkpStorage, o.output, os.Stdout); err != nil {
+			log.Fatalf("[ERROR] Unable to list the backups: %s\n", err)
+		}
+	case "inspect":
+		if err = inspectBackup(folder, bkpStorage, o.output, os.Stdout); err != nil {
+			log.Fatalf("[ERROR] Unable to inspect the backup: %s\n", err)
+		}
+	case "prune":
+		if err = pruneBackups(folder, bkpStorage, o.retention, o.dryRun, o.output, os.Stdout); err != nil {
+			log.Fatalf("[ERROR] Unable to prune the backups: %s\n", err)
+		}
+	}
 }
 
 func main() {
-	var (
-		s3DateSuffix, appendRestore           bool
-		batchSize, waitTime                   int64
-		action, tableName, s3Bucket, s3Folder string
-	)
-
-	flag.StringVar(&action, "action", "backup", "Action to perform. Only accept 'backup' or 'restore'. Environment variable: ACTION")
-	flag.StringVar(&tableName, "dynamo-table", "", "Name of the Dynamo table to backup from or to restore in. Environment variable: DYNAMO_TABLE")
-	flag.StringVar(&s3Bucket, "s3-bucket", "", "Name of the s3 bucket where to put the backup or where to restore from. Environment variable: S3_BUCKET")
-	flag.StringVar(&s3Folder, "s3-folder", "", "Path inside the s3 bucket where to put or grab (for restore) the backup. Environment variable: S3_FOLDER")
-	flag.BoolVar(&s3DateSuffix, "s3-date-folder", false, "Adds an autogenenated suffix folder named using the UTC date in the format YYYY-mm-dd-HH24-MI-SS to the provided S3 folder. Environment variable: S3_DATE_FOLDER")
-	flag.Int64Var(&batchSize, "batch-size", 1000, "Max number of records to read from the dynamo table at once or to write in case of a restore. Environment variable: BATCH_SIZE")
-	flag.Int64Var(&waitTime, "wait-ms", 100, "Number of milliseconds to wait between batches. If a ProvisionedThroughputExceededException is encountered, the script will wait twice that amount of time before retrying. Environment variable: WAIT_MS")
-	flag.BoolVar(&appendRestore, "restore-append", false, "Appends the rows to a non-empty table when restoring instead of aborting. Environment variable: RESTORE_APPEND")
+	var configFile, jobName string
+	opts := &options{}
+	opts.register(flag.CommandLine)
+	flag.StringVar(&configFile, "config", "", "YAML file describing the jobs to run, with the settings of each job named after the flags, and their defaults. The flags set on the command line or by environment variables override the settings of the file. Environment variable: CONFIG")
+	flag.StringVar(&jobName, "job", "", "Name of the only job of the -config file to run. Environment variable: JOB")
 	envflag.Parse()
 
-	// For now we only backup to s3 but this can easily evolve in the future
+	jobs := []job{{opts: opts}}
+	if configFile != "" {
+		overrides := map[string]string{}
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "config" && f.Name != "job" {
+				overrides[f.Name] = f.Value.String()
+			}
+		})
+		var err error
+		if jobs, err = loadJobs(configFile, jobName, overrides); err != nil {
+			log.Fatalf("[ERROR] Unable to load the jobs: %s\n", err)
+		}
+	}
+	// All the jobs are checked before running any of them
+	for _, j := range jobs {
+		if err := j.opts.validate(); err != nil {
+			if j.name != "" {
+				log.Fatalf("[ERROR] Job %s: %s\n", j.name, err)
+			}
+			log.Fatalf("[ERROR] %s\n", err)
+		}
+	}
+
 	awsSess := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
-	bkpStorage := storage.NewS3Backup(awsSess)
-	dynamoSvc = dynamodb.New(awsSess)
-	c = make(chan map[string]*dynamodb.AttributeValue)
-	bkpStorage.DataPipe = c
 
-	switch action {
-	case "backup":
-		backupTable(tableName, batchSize, time.Duration(waitTime)*time.Millisecond, s3Bucket, s3Folder, s3DateSuffix, bkpStorage)
-	case "restore":
-		restoreTable(s3Bucket, s3Folder, tableName, batchSize, time.Duration(waitTime)*time.Millisecond, appendRestore, bkpStorage)
+	for _, j := range jobs {
+		if j.name != "" {
+			log.Printf("Running the job %s\n", j.name)
+		}
+		run(j.opts, awsSess)
+	}
+	// The output of list, inspect and prune is kept free of other messages
+	switch jobs[len(jobs)-1].opts.action {
+	case "list", "inspect", "prune":
 	default:
-		log.Fatalf("[ERROR] Unknown action given. See help for available actions.")
+		log.Println("All done!")
 	}
-	log.Println("All done!")
 }
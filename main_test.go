@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorageURL(t *testing.T) {
+	urlTest := []struct {
+		location, bucket, folder, localDir, expected string
+	}{
+		{location: "mem://tests/myTable", bucket: "ignored", expected: "mem://tests/myTable"},
+		{bucket: "my-bucket", folder: "backups/myTable", expected: "s3://my-bucket/backups/myTable"},
+		{bucket: "my-bucket", folder: "/backups/myTable", expected: "s3://my-bucket/backups/myTable"},
+		{bucket: "my-bucket", expected: "s3://my-bucket/"},
+		{bucket: "ignored", folder: "myTable", localDir: "/var/backups", expected: "file:///var/backups/myTable"},
+	}
+	for _, item := range urlTest {
+		result, err := storageURL(item.location, item.bucket, item.folder, item.localDir)
+		if err != nil {
+			t.Fatalf("Unexpected error for %+v: %s", item, err)
+		}
+		if result != item.expected {
+			t.Errorf("Expecting %s for %+v. Got: %s", item.expected, item, result)
+		}
+	}
+	if _, err := storageURL("", "", "myTable", ""); err == nil {
+		t.Errorf("Expecting an error when no storage is provided")
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	expected := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, value := range []string{"2019-01-02T03:04:05Z", "2019-01-02T04:04:05+01:00", "2019-01-02-03-04-05"} {
+		result, err := parseTimestamp(value)
+		if err != nil || !result.Equal(expected) {
+			t.Errorf("Expecting %s for %s, got %s, %v", expected, value, result, err)
+		}
+	}
+	if _, err := parseTimestamp("yesterday"); err == nil {
+		t.Errorf("Expecting an error for an invalid timestamp")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// options holds the settings of a job, given by the command-line flags and
+// the environment variables or by a job of the -config file
+type options struct {
+	s3DateSuffix, appendRestore           bool
+	createRestore, resumeBackup           bool
+	resumeRestore, staging                bool
+	forceRestore, repairSuccess           bool
+	restoreLatest                         bool
+	restoreAsOf, output                   string
+	changesSource                         string
+	incremental                           incrementalSettings
+	batchSize, waitTime, scanSegments     int64
+	restoreWorkers                        int
+	action, tableName, s3Bucket, s3Folder string
+	tableSelection, tableTagFilters       string
+	tableWorkers                          int
+	localDir, target, source, compression string
+	restoreStateFile                      string
+	throughput                            throughputSettings
+	retryMaxAttempts                      int
+	retryBaseMs, retryMaxDelayMs          int64
+	retryMaxElapsedMs                     int64
+	retention                             retentionPolicy
+	keepWithin                            string
+	dryRun                                bool
+	dynamoEndpoint, dynamoRegion          string
+	s3Endpoint, s3Region                  string
+	s3ForcePathStyle                      bool
+	streamSegmentMs, streamPollMs         int64
+	streamOnce                            bool
+	compactDir                            string
+	// location is the URL of the storage folder of the job, set by validate
+	location string
+}
+
+// register declares the flags setting the options in the given flag set
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.action, "action", "backup", "Action to perform: 'backup', 'restore', 'verify' (checks the files of a backup against its manifest without accessing DynamoDB), 'list' (lists the backups of the source folder and of its date folders), 'inspect' (shows the details of the backup of the source folder), 'repair' (rebuilds the manifest of the backup of the source folder from the valid data files it holds), 'stream-archive' (archives the changes of the stream of the table in date folders of the target folder until interrupted), 'compact' (merges the incremental backup of the source folder and the backups it is based on into a full backup in the target folder without accessing DynamoDB) or 'prune' (removes the backups of the date folders of the target folder that the -keep-* options do not keep). Environment variable: ACTION")
+	fs.StringVar(&o.tableName, "dynamo-table", "", "Name of the Dynamo table to backup from or to restore in. Environment variable: DYNAMO_TABLE")
+	fs.StringVar(&o.tableSelection, "dynamo-tables", "", "Comma separated list of the tables to backup, each in its own sub-folder of the target folder named after the table. Accepts glob patterns such as 'prod-*' and regular expressions enclosed in slashes such as '/^prod-(a|b)$/'. Replaces -dynamo-table. Environment variable: DYNAMO_TABLES")
+	fs.StringVar(&o.tableTagFilters, "dynamo-table-tags", "", "Comma separated list of key=value tag filters selecting the tables to backup among all the tables, or among the -dynamo-tables if given. A key without value matches any value of the tag. The dynamodbdump:batch-size, dynamodbdump:wait-ms, dynamodbdump:scan-segments and dynamodbdump:folder tags of a table override the settings of its backup. Environment variable: DYNAMO_TABLE_TAGS")
+	fs.IntVar(&o.tableWorkers, "table-workers", 4, "Number of tables backed up in parallel when using -dynamo-tables. Environment variable: TABLE_WORKERS")
+	fs.StringVar(&o.dynamoEndpoint, "dynamo-endpoint", "", "URL of the DynamoDB endpoint to use instead of the one of the region, for example http://localhost:8000 for DynamoDB Local. Environment variable: DYNAMO_ENDPOINT")
+	fs.StringVar(&o.dynamoRegion, "dynamo-region", "", "Region of the DynamoDB tables. Defaults to the region of the AWS configuration. Environment variable: DYNAMO_REGION")
+	fs.StringVar(&o.s3Endpoint, "s3-endpoint", "", "URL of the S3 endpoint to use instead of the one of the region, for example http://localhost:9000 for MinIO. Environment variable: S3_ENDPOINT")
+	fs.StringVar(&o.s3Region, "s3-region", "", "Region of the S3 bucket. Defaults to the region of the AWS configuration. Environment variable: S3_REGION")
+	fs.BoolVar(&o.s3ForcePathStyle, "s3-force-path-style", false, "Uses path-style URLs (http://endpoint/bucket/key) instead of virtual-hosted-style ones for S3, as required by most S3 compatible services such as MinIO. Environment variable: S3_FORCE_PATH_STYLE")
+	fs.StringVar(&o.target, "target", "", fmt.Sprintf("URL of the folder where to put the backup, for example s3://bucket/prefix or file:///var/backups/x. Supported schemes: %v. Environment variable: TARGET", storage.Schemes()))
+	fs.StringVar(&o.source, "source", "", "URL of the folder where to grab the backup to restore, verify, repair, list or inspect from. Accepts the same schemes as -target. Environment variable: SOURCE")
+	fs.StringVar(&o.s3Bucket, "s3-bucket", "", "Name of the s3 bucket where to put the backup or where to restore from. Alias of -target and -source s3://<s3-bucket>/<s3-folder>. Environment variable: S3_BUCKET")
+	fs.StringVar(&o.s3Folder, "s3-folder", "", "Path inside the s3 bucket (or inside -local-dir) where to put or grab (for restore) the backup. Environment variable: S3_FOLDER")
+	fs.StringVar(&o.localDir, "local-dir", "", "Local directory where to put or grab (for restore) the backup instead of s3. Alias of -target and -source file://<local-dir>/<s3-folder>. Environment variable: LOCAL_DIR")
+	fs.StringVar(&o.output, "output", "text", "Format of the output of the list, inspect and prune actions: 'text' or 'json'. Environment variable: OUTPUT")
+	fs.StringVar(&o.compression, "compression", storage.CompressionNone, "Compression of the backup data files: 'gzip', 'zstd' or 'none'. Compressed backups are not compatible with the AWS datapipelines. The compression is detected automatically on restore. Environment variable: COMPRESSION")
+	fs.StringVar(&o.incremental.attribute, "incremental-attribute", "", "Attribute of the items holding the time they were last changed. When given, the backup is incremental and only holds the items changed since -since. Environment variable: INCREMENTAL_ATTRIBUTE")
+	fs.BoolVar(&o.incremental.diff, "incremental-diff", false, "Makes an incremental backup of the items added, changed or deleted since the latest complete backup of the date folders of the target folder, found by comparing the hashes of their keys and content with the ones recorded by this backup. A full backup is made if there is none. Requires -s3-date-folder. Environment variable: INCREMENTAL_DIFF")
+	fs.StringVar(&o.incremental.format, "incremental-format", incrementalRFC3339, "Format of the times of the -incremental-attribute: 'rfc3339' for strings such as 2006-01-02T15:04:05Z in UTC, 'epoch' or 'epoch-ms' for numbers of seconds or milliseconds. Environment variable: INCREMENTAL_FORMAT")
+	fs.StringVar(&o.incremental.since, "since", "", "Time from which the changed items are backed up by an incremental backup (RFC 3339 or YYYY-mm-dd-HH24-MI-SS in UTC), or 'last' for the start of the latest complete backup of the date folders of the target folder. The backup is based on the latest complete backup of the date folders made at or before this time. Requires -s3-date-folder. Environment variable: SINCE")
+	fs.BoolVar(&o.s3DateSuffix, "s3-date-folder", false, "Adds an autogenenated suffix folder named using the UTC date in the format YYYY-mm-dd-HH24-MI-SS to the provided S3 folder. Environment variable: S3_DATE_FOLDER")
+	fs.Int64Var(&o.batchSize, "batch-size", 1000, "Max number of records to read from the dynamo table at once or to write in case of a restore. Environment variable: BATCH_SIZE")
+	fs.Int64Var(&o.waitTime, "wait-ms", 100, "Number of milliseconds to wait between batches. Environment variable: WAIT_MS")
+	fs.Int64Var(&o.scanSegments, "scan-segments", 1, "Number of segments to split the table scan into when doing a backup. Each segment is scanned in parallel by its own goroutine. Environment variable: SCAN_SEGMENTS")
+	fs.IntVar(&o.restoreWorkers, "restore-workers", 1, "Number of workers writing to the table in parallel when restoring. They share the rate allowed by -batch-size and -wait-ms. Environment variable: RESTORE_WORKERS")
+	fs.Float64Var(&o.throughput.utilization, "target-utilization", 0, "Share of the provisioned capacity of the table to consume, between 0 and 1. When set, the scan page size or the write rate is adjusted continuously from the capacity consumed instead of using -wait-ms. Environment variable: TARGET_UTILIZATION")
+	fs.Float64Var(&o.throughput.ceiling, "max-capacity-units", 0, "Number of capacity units to consume per second on on-demand tables when -target-utilization is set. Also caps the target on provisioned tables. Environment variable: MAX_CAPACITY_UNITS")
+	fs.IntVar(&o.retryMaxAttempts, "retry-max-attempts", retry.maxAttempts, "Maximum number of attempts of a DynamoDB call failing with a retryable error (throttling, internal server error...). 0 means no limit. Environment variable: RETRY_MAX_ATTEMPTS")
+	fs.Int64Var(&o.retryBaseMs, "retry-base-ms", int64(retry.baseDelay/time.Millisecond), "Maximum number of milliseconds to wait before the 1st retry of a DynamoDB call. It doubles for each following retry and the actual wait is picked at random below it. Environment variable: RETRY_BASE_MS")
+	fs.Int64Var(&o.retryMaxDelayMs, "retry-max-delay-ms", int64(retry.maxDelay/time.Millisecond), "Maximum number of milliseconds to wait between 2 attempts of a DynamoDB call. Environment variable: RETRY_MAX_DELAY_MS")
+	fs.Int64Var(&o.retryMaxElapsedMs, "retry-max-elapsed-ms", int64(retry.maxElapsed/time.Millisecond), "Maximum number of milliseconds spent retrying a DynamoDB call before giving up. 0 means no limit. Environment variable: RETRY_MAX_ELAPSED_MS")
+	fs.BoolVar(&o.appendRestore, "restore-append", false, "Appends the rows to a non-empty table when restoring instead of aborting. Environment variable: RESTORE_APPEND")
+	fs.BoolVar(&o.resumeBackup, "resume", false, "Resumes an interrupted backup from the checkpoint saved in the target folder. The folder has to be the one of the interrupted backup, so this is not compatible with -s3-date-folder. Environment variable: RESUME")
+	fs.BoolVar(&o.staging, "staging", false, "Writes the data files of the backup in a _temporary folder and moves them to the backup folder only once all of them are written. Environment variable: STAGING")
+	fs.BoolVar(&o.createRestore, "restore-create-table", false, "Creates the table from the schema saved with the backup if it does not exist when restoring. Environment variable: RESTORE_CREATE_TABLE")
+	fs.BoolVar(&o.restoreLatest, "restore-latest", false, "Restores the newest complete backup found in the date folders (see -s3-date-folder) of the source folder. Environment variable: RESTORE_LATEST")
+	fs.StringVar(&o.restoreAsOf, "restore-as-of", "", "Restores the newest complete backup made at or before the given time (RFC 3339 or YYYY-mm-dd-HH24-MI-SS in UTC) found in the date folders of the source folder, and then the changes of -changes-source made up to this time if given. Environment variable: RESTORE_AS_OF")
+	fs.StringVar(&o.restoreAsOf, "as-of", "", "Same as -restore-as-of. Environment variable: AS_OF")
+	fs.StringVar(&o.changesSource, "changes-source", "", "URL of the folder of the changes archived by -action stream-archive to replay after restoring the backup found by -restore-latest or -restore-as-of. Environment variable: CHANGES_SOURCE")
+	fs.BoolVar(&o.forceRestore, "restore-force", false, "Restores the backup even if it has no _SUCCESS flag. If it has no manifest either, all the data files found in the folder are restored. Environment variable: RESTORE_FORCE")
+	fs.BoolVar(&o.repairSuccess, "repair-success", false, "Flags the backup as complete with a _SUCCESS file after -action repair if all its data files are valid. Environment variable: REPAIR_SUCCESS")
+	fs.BoolVar(&o.resumeRestore, "resume-restore", false, "Resumes an interrupted restore, skipping the data already written in the table according to the saved state of the restore. Environment variable: RESUME_RESTORE")
+	fs.StringVar(&o.restoreStateFile, "restore-state-file", "", "Local file where to save the state of a restore. By default the state is saved in a _RESTORE_STATE file in the backup folder. Environment variable: RESTORE_STATE_FILE")
+	fs.IntVar(&o.retention.last, "keep-last", 0, "Number of newest complete backups kept by -action prune. Environment variable: KEEP_LAST")
+	fs.StringVar(&o.keepWithin, "keep-within", "", "Age under which all the backups are kept by -action prune, for example 72h, 30d or 4w. Environment variable: KEEP_WITHIN")
+	fs.IntVar(&o.retention.daily, "keep-daily", 0, "Number of days for which -action prune keeps the newest complete backup of the day. Environment variable: KEEP_DAILY")
+	fs.IntVar(&o.retention.weekly, "keep-weekly", 0, "Number of weeks for which -action prune keeps the newest complete backup of the week. Environment variable: KEEP_WEEKLY")
+	fs.IntVar(&o.retention.monthly, "keep-monthly", 0, "Number of months for which -action prune keeps the newest complete backup of the month. Environment variable: KEEP_MONTHLY")
+	fs.Int64Var(&o.streamSegmentMs, "stream-segment-ms", 900000, "Period in milliseconds of the changes archived in each date folder by -action stream-archive. Environment variable: STREAM_SEGMENT_MS")
+	fs.Int64Var(&o.streamPollMs, "stream-poll-ms", 1000, "Time in milliseconds waited by -action stream-archive before reading the stream again once all its records are archived. Environment variable: STREAM_POLL_MS")
+	fs.BoolVar(&o.streamOnce, "stream-once", false, "Stops -action stream-archive once all the available records of the stream are archived instead of waiting for new ones. Environment variable: STREAM_ONCE")
+	fs.StringVar(&o.compactDir, "compact-dir", "", "Folder of the temporary files of -action compact, which need about as much space as the uncompressed backups to compact. Defaults to the temporary folder of the system. Environment variable: COMPACT_DIR")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Only shows the backups -action prune would remove without removing them. Environment variable: DRY_RUN")
+}
+
+// validate checks the consistency of the options, without any call to AWS,
+// and sets the ones derived from the others
+func (o *options) validate() error {
+	switch o.action {
+	case "backup":
+		if o.tableName == "" && o.tableSelection == "" && o.tableTagFilters == "" {
+			return fmt.Errorf("a backup requires -dynamo-table, -dynamo-tables or -dynamo-table-tags")
+		}
+	case "restore":
+		if o.tableName == "" {
+			return fmt.Errorf("a restore requires -dynamo-table")
+		}
+	case "stream-archive":
+		if o.tableName == "" {
+			return fmt.Errorf("-action stream-archive requires -dynamo-table")
+		}
+		if o.streamSegmentMs < 1000 || o.streamPollMs < 0 {
+			return fmt.Errorf("-stream-segment-ms has to be at least 1000 and -stream-poll-ms can't be negative")
+		}
+	case "compact":
+		if o.source == "" || o.target == "" {
+			return fmt.Errorf("-action compact requires -source and -target")
+		}
+		if err := checkStorageURL(o.target); err != nil {
+			return fmt.Errorf("-target: %s", err)
+		}
+	case "verify", "repair", "list", "inspect", "prune":
+	default:
+		return fmt.Errorf("unknown action %q. See help for available actions", o.action)
+	}
+	if o.output != "text" && o.output != "json" {
+		return fmt.Errorf("unknown output format %q, expecting 'text' or 'json'", o.output)
+	}
+	if err := storage.CheckCompression(o.compression); err != nil {
+		return err
+	}
+	if o.throughput.utilization < 0 || o.throughput.utilization > 1 {
+		return fmt.Errorf("-target-utilization has to be between 0 and 1")
+	}
+	if o.restoreAsOf != "" {
+		if _, err := parseTimestamp(o.restoreAsOf); err != nil {
+			return fmt.Errorf("-restore-as-of: %s", err)
+		}
+	}
+	if o.incremental.attribute != "" || o.incremental.since != "" || o.incremental.diff {
+		if err := o.incremental.validate(o.action, o.s3DateSuffix); err != nil {
+			return err
+		}
+	}
+	if o.changesSource != "" {
+		if o.action != "restore" || (!o.restoreLatest && o.restoreAsOf == "") {
+			return fmt.Errorf("-changes-source can only be used for a restore with -restore-latest or -restore-as-of")
+		}
+		if err := checkStorageURL(o.changesSource); err != nil {
+			return fmt.Errorf("-changes-source: %s", err)
+		}
+	}
+	if o.keepWithin != "" {
+		within, err := parseRetention(o.keepWithin)
+		if err != nil {
+			return fmt.Errorf("-keep-within: %s", err)
+		}
+		o.retention.within = within
+	}
+	if o.action == "prune" && o.retention.empty() {
+		return fmt.Errorf("-action prune requires at least one of -keep-last, -keep-within, -keep-daily, -keep-weekly or -keep-monthly")
+	}
+	if (o.tableSelection != "" || o.tableTagFilters != "") && (o.tableName != "" || o.action != "backup") {
+		return fmt.Errorf("-dynamo-tables and -dynamo-table-tags can only be used for a backup and replace -dynamo-table")
+	}
+	for name, endpoint := range map[string]string{"-dynamo-endpoint": o.dynamoEndpoint, "-s3-endpoint": o.s3Endpoint} {
+		if u, err := url.Parse(endpoint); endpoint != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+			return fmt.Errorf("%s: invalid URL %q, expecting for example http://localhost:8000", name, endpoint)
+		}
+	}
+	if o.resumeBackup && o.s3DateSuffix {
+		return fmt.Errorf("-resume can't be used with -s3-date-folder, please provide the folder of the interrupted backup instead")
+	}
+
+	location := o.target
+	switch o.action {
+	case "restore", "verify", "repair", "list", "inspect", "compact":
+		location = o.source
+	case "prune":
+		if location == "" {
+			location = o.source
+		}
+	}
+	location, err := storageURL(location, o.s3Bucket, o.s3Folder, o.localDir)
+	if err != nil {
+		return err
+	}
+	if err = checkStorageURL(location); err != nil {
+		return err
+	}
+	o.location = location
+	return nil
+}
+
+// checkStorageURL returns an error if the given storage URL has no registered
+// scheme
+func checkStorageURL(location string) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("invalid storage URL %s: %s", location, err)
+	}
+	for _, scheme := range storage.Schemes() {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported storage URL %s, expecting one of the schemes %v", location, storage.Schemes())
+}
+
+// retryPolicy returns the retry policy of the DynamoDB calls set by the options
+func (o *options) retryPolicy() retryPolicy {
+	return retryPolicy{
+		baseDelay:   time.Duration(o.retryBaseMs) * time.Millisecond,
+		maxDelay:    time.Duration(o.retryMaxDelayMs) * time.Millisecond,
+		maxAttempts: o.retryMaxAttempts,
+		maxElapsed:  time.Duration(o.retryMaxElapsedMs) * time.Millisecond,
+	}
+}
+
+// awsConfig returns the settings overriding the ones of the AWS session for a
+// service, or nil if none. A nil config is ignored by the AWS SDK.
+func awsConfig(endpoint, region string, forcePathStyle bool) *aws.Config {
+	if endpoint == "" && region == "" && !forcePathStyle {
+		return nil
+	}
+	cfg := &aws.Config{}
+	if endpoint != "" {
+		cfg.Endpoint = aws.String(endpoint)
+	}
+	if region != "" {
+		cfg.Region = aws.String(region)
+	}
+	if forcePathStyle {
+		cfg.S3ForcePathStyle = aws.Bool(true)
+	}
+	return cfg
+}
+
+// dynamoConfig returns the settings of the DynamoDB client
+func (o *options) dynamoConfig() *aws.Config {
+	return awsConfig(o.dynamoEndpoint, o.dynamoRegion, false)
+}
+
+// s3Config returns the settings of the S3 client
+func (o *options) s3Config() *aws.Config {
+	return awsConfig(o.s3Endpoint, o.s3Region, o.s3ForcePathStyle)
+}
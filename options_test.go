@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestValidateOptions(t *testing.T) {
+	valid := func() *options {
+		return &options{action: "backup", tableName: "songs", target: "s3://mybucket/songs", output: "text", compression: "none"}
+	}
+	testCases := map[string]func(o *options){
+		"a backup requires":        func(o *options) { o.tableName = "" },
+		"a restore requires":       func(o *options) { o.action, o.tableName = "restore", "" },
+		"unknown action":           func(o *options) { o.action = "copy" },
+		"unknown output":           func(o *options) { o.output = "xml" },
+		"unknown compression":      func(o *options) { o.compression = "lz4" },
+		"-target-utilization":      func(o *options) { o.throughput.utilization = 2 },
+		"-restore-as-of":           func(o *options) { o.restoreAsOf = "yesterday" },
+		"-keep-within":             func(o *options) { o.keepWithin = "a while" },
+		"requires at least one":    func(o *options) { o.action = "prune" },
+		"replace -dynamo-table":    func(o *options) { o.tableSelection = "prod-*" },
+		"-resume can't be used":    func(o *options) { o.resumeBackup, o.s3DateSuffix = true, true },
+		"no storage provided":      func(o *options) { o.target = "" },
+		"unsupported storage":      func(o *options) { o.target = "ftp://host/folder" },
+		"-dynamo-endpoint":         func(o *options) { o.dynamoEndpoint = "localhost:8000" },
+		"-s3-endpoint":             func(o *options) { o.s3Endpoint = "http://" },
+		"-action compact requires": func(o *options) { o.action, o.source, o.target = "compact", o.target, "" },
+		"-stream-segment-ms":       func(o *options) { o.action, o.streamSegmentMs = "stream-archive", 10 },
+		"-since last requires": func(o *options) {
+			o.incremental = incrementalSettings{attribute: "updated_at", format: incrementalRFC3339, since: "last"}
+		},
+		"unknown -incremental-format": func(o *options) {
+			o.incremental = incrementalSettings{attribute: "updated_at", format: "date", since: "2019-01-01T02:00:00Z"}
+		},
+		"-incremental-diff requires": func(o *options) { o.incremental.diff = true },
+		"can't be used with -incremental-attribute": func(o *options) {
+			o.s3DateSuffix, o.incremental = true, incrementalSettings{attribute: "updated_at", diff: true}
+		},
+		"requires both -incremental-attribute": func(o *options) { o.incremental.since = "last" },
+		"can only be used for a restore": func(o *options) {
+			o.action, o.source, o.changesSource = "restore", o.target, "s3://mybucket/changes"
+		},
+		"-changes-source: unsupported": func(o *options) {
+			o.action, o.source, o.restoreLatest, o.changesSource = "restore", o.target, true, "changes"
+		},
+	}
+	if err := valid().validate(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for expected, change := range testCases {
+		o := valid()
+		change(o)
+		if err := o.validate(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expecting an error containing %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestAWSConfig(t *testing.T) {
+	o := &options{}
+	if o.dynamoConfig() != nil || o.s3Config() != nil {
+		t.Errorf("Expecting no config overriding the session by default")
+	}
+	o = &options{dynamoEndpoint: "http://localhost:8000", s3Region: "eu-west-1", s3Endpoint: "http://localhost:9000", s3ForcePathStyle: true}
+	if cfg := o.dynamoConfig(); !reflect.DeepEqual(cfg, &aws.Config{Endpoint: aws.String("http://localhost:8000")}) {
+		t.Errorf("Unexpected DynamoDB config: %+v", cfg)
+	}
+	expected := &aws.Config{Endpoint: aws.String("http://localhost:9000"), Region: aws.String("eu-west-1"), S3ForcePathStyle: aws.Bool(true)}
+	if cfg := o.s3Config(); !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Unexpected S3 config: %+v", cfg)
+	}
+}
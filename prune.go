@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+)
+
+// retentionPolicy describes the backups of the date folders kept by -action
+// prune. A backup is kept as soon as one of the rules keeps it.
+type retentionPolicy struct {
+	// last is the number of newest complete backups to keep
+	last int
+	// within is the age under which all the backups are kept
+	within time.Duration
+	// daily, weekly and monthly are the number of days, weeks and months for
+	// which the newest complete backup is kept
+	daily, weekly, monthly int
+}
+
+// empty returns whether the policy has no rule, which would remove all the
+// backups but the newest complete one
+func (p retentionPolicy) empty() bool {
+	return p.last <= 0 && p.within <= 0 && p.daily <= 0 && p.weekly <= 0 && p.monthly <= 0
+}
+
+// pruneDecision is the outcome of the retention policy for a backup
+type pruneDecision struct {
+	storage.BackupInfo
+	Keep bool `json:"keep"`
+	// Reasons are the rules keeping the backup
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// periodRule keeps the newest complete backup of each of the count most recent
+// periods, the period of a backup being given by key
+type periodRule struct {
+	name  string
+	count int
+	key   func(time.Time) string
+	seen  map[string]bool
+}
+
+// keeps returns whether the rule keeps the complete backup made at the given
+// time, the backups being given newest first
+func (r *periodRule) keeps(t time.Time) bool {
+	key := r.key(t)
+	if r.seen[key] || len(r.seen) >= r.count {
+		return false
+	}
+	r.seen[key] = true
+	return true
+}
+
+// apply returns the decisions of the policy for the given backups, newest
+// first. Only the backups made in a date folder are considered. The newest
+// complete backup is always kept, as well as the backups newer than it that
+// might still be in progress. The backups that a kept incremental backup is
+// based on are kept too so that it can still be restored.
+func (p retentionPolicy) apply(backups []storage.BackupInfo, now time.Time) []pruneDecision {
+	decisions := []pruneDecision{}
+	for _, backup := range backups {
+		if backup.Time != nil {
+			decisions = append(decisions, pruneDecision{BackupInfo: backup})
+		}
+	}
+	sort.SliceStable(decisions, func(i, j int) bool { return decisions[i].Time.After(*decisions[j].Time) })
+
+	periods := []*periodRule{
+		{name: "daily", count: p.daily, key: func(t time.Time) string { return t.Format("2006-01-02") }},
+		{name: "weekly", count: p.weekly, key: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{name: "monthly", count: p.monthly, key: func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, period := range periods {
+		period.seen = map[string]bool{}
+	}
+
+	complete := 0
+	for i := range decisions {
+		decision := &decisions[i]
+		keep := func(reason string) {
+			decision.Keep = true
+			decision.Reasons = append(decision.Reasons, reason)
+		}
+		if p.within > 0 && now.Sub(*decision.Time) <= p.within {
+			keep("within")
+		}
+		if decision.Status != storage.StatusComplete {
+			if complete == 0 {
+				keep("newer than the newest complete backup")
+			}
+			continue
+		}
+		complete++
+		if complete == 1 {
+			keep("newest complete backup")
+		}
+		if complete <= p.last {
+			keep("last")
+		}
+		for _, period := range periods {
+			if period.keeps(*decision.Time) {
+				keep(period.name)
+			}
+		}
+	}
+
+	indexes := map[string]int{}
+	for i, decision := range decisions {
+		indexes[decision.URL] = i
+	}
+	for i := range decisions {
+		if !decisions[i].Keep {
+			continue
+		}
+		// Stops at the parents already kept, which also ends the loops
+		for parent, ok := indexes[decisions[i].Parent]; ok; parent, ok = indexes[decisions[parent].Parent] {
+			if decisions[parent].Keep {
+				break
+			}
+			decisions[parent].Keep = true
+			decisions[parent].Reasons = append(decisions[parent].Reasons, "parent of "+decisions[i].URL)
+		}
+	}
+	return decisions
+}
+
+// parseRetention parses a duration given either in the Go format (72h) or as
+// a number of days (30d) or weeks (4w)
+func parseRetention(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(value, suffix) {
+			count, err := strconv.Atoi(strings.TrimSuffix(value, suffix))
+			if err != nil || count < 0 {
+				break
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid duration %q, expecting for example 72h, 30d or 4w", value)
+	}
+	return duration, nil
+}
+
+// pruneBackups removes the backups of the date folders of the given folder
+// that the policy does not keep, unless dryRun is set, and writes the
+// decisions either as a table or as json
+func pruneBackups(folder *storage.FileInput, store storage.BackupIface, policy retentionPolicy, dryRun bool, output string, w io.Writer) error {
+	backups, err := store.ListBackups(folder)
+	if err != nil {
+		return err
+	}
+	decisions := policy.apply(backups, time.Now().UTC())
+
+	failed := 0
+	for _, decision := range decisions {
+		if decision.Keep || dryRun {
+			continue
+		}
+		if err = store.DeleteBackup(decision.Folder); err != nil {
+			log.Printf("[ERROR] Unable to remove the backup %s: %s\n", decision.URL, err)
+			failed++
+		}
+	}
+
+	if output == "json" {
+		err = writeJSON(w, decisions)
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ACTION\tDATE\tSTATUS\tURL\tREASONS")
+		for _, decision := range decisions {
+			action := "remove"
+			if decision.Keep {
+				action = "keep"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", action, formatTime(decision.Time), decision.Status, decision.URL, strings.Join(decision.Reasons, ", "))
+		}
+		err = tw.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d backups could not be removed", failed)
+	}
+	return nil
+}
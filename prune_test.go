@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	now := time.Date(2019, 3, 10, 12, 0, 0, 0, time.UTC)
+	backups := []storage.BackupInfo{}
+	add := func(date, status string) {
+		t, _ := time.Parse(storage.DateFolderFormat, date)
+		backups = append(backups, storage.BackupInfo{URL: date, Time: &t, Status: status})
+	}
+	// Oldest first, as listed by ListBackups
+	add("2019-01-15-02-00-00", storage.StatusComplete)
+	add("2019-01-31-02-00-00", storage.StatusComplete)
+	add("2019-02-28-02-00-00", storage.StatusComplete)
+	add("2019-03-01-02-00-00", storage.StatusFailed)
+	add("2019-03-08-02-00-00", storage.StatusComplete)
+	add("2019-03-09-02-00-00", storage.StatusComplete)
+	add("2019-03-09-14-00-00", storage.StatusComplete)
+	add("2019-03-10-02-00-00", storage.StatusFailed)
+	// The folder itself is never pruned
+	backups = append(backups, storage.BackupInfo{URL: "undated", Status: storage.StatusComplete})
+
+	testCases := []struct {
+		policy   retentionPolicy
+		expected []string
+	}{
+		{policy: retentionPolicy{}, expected: []string{"2019-03-10-02-00-00", "2019-03-09-14-00-00"}},
+		{policy: retentionPolicy{last: 3}, expected: []string{"2019-03-10-02-00-00", "2019-03-09-14-00-00", "2019-03-09-02-00-00", "2019-03-08-02-00-00"}},
+		{policy: retentionPolicy{within: 48 * time.Hour}, expected: []string{"2019-03-10-02-00-00", "2019-03-09-14-00-00", "2019-03-09-02-00-00"}},
+		{policy: retentionPolicy{daily: 2}, expected: []string{"2019-03-10-02-00-00", "2019-03-09-14-00-00", "2019-03-08-02-00-00"}},
+		// 2019-03-08 is in the same ISO week as 2019-03-09
+		{policy: retentionPolicy{weekly: 2}, expected: []string{"2019-03-10-02-00-00", "2019-03-09-14-00-00", "2019-02-28-02-00-00"}},
+		{policy: retentionPolicy{monthly: 12}, expected: []string{"2019-03-10-02-00-00", "2019-03-09-14-00-00", "2019-02-28-02-00-00", "2019-01-31-02-00-00"}},
+	}
+	for _, tc := range testCases {
+		kept := []string{}
+		for _, decision := range tc.policy.apply(backups, now) {
+			if decision.Keep {
+				kept = append(kept, decision.URL)
+			}
+		}
+		if !reflect.DeepEqual(kept, tc.expected) {
+			t.Errorf("Expecting %+v to keep %v, got %v", tc.policy, tc.expected, kept)
+		}
+	}
+}
+
+func TestRetentionPolicyChain(t *testing.T) {
+	now := time.Date(2019, 3, 10, 12, 0, 0, 0, time.UTC)
+	backups := []storage.BackupInfo{}
+	add := func(date, parent string) {
+		t, _ := time.Parse(storage.DateFolderFormat, date)
+		backups = append(backups, storage.BackupInfo{URL: date, Time: &t, Status: storage.StatusComplete, Parent: parent})
+	}
+	add("2019-03-01-02-00-00", "")
+	add("2019-03-02-02-00-00", "2019-03-01-02-00-00")
+	add("2019-03-03-02-00-00", "2019-03-02-02-00-00")
+	add("2019-03-08-02-00-00", "")
+	add("2019-03-09-02-00-00", "2019-03-08-02-00-00")
+	// Based on a backup of another folder
+	add("2019-03-10-02-00-00", "s3://bucket/other/2019-03-10-01-00-00")
+
+	testCases := []struct {
+		policy   retentionPolicy
+		expected []string
+	}{
+		{policy: retentionPolicy{last: 2}, expected: []string{"2019-03-10-02-00-00", "2019-03-09-02-00-00", "2019-03-08-02-00-00"}},
+		{policy: retentionPolicy{monthly: 2}, expected: []string{"2019-03-10-02-00-00"}},
+		{policy: retentionPolicy{within: 200 * time.Hour}, expected: []string{"2019-03-10-02-00-00", "2019-03-09-02-00-00", "2019-03-08-02-00-00", "2019-03-03-02-00-00", "2019-03-02-02-00-00", "2019-03-01-02-00-00"}},
+	}
+	for _, tc := range testCases {
+		kept := []string{}
+		for _, decision := range tc.policy.apply(backups, now) {
+			if decision.Keep {
+				kept = append(kept, decision.URL)
+			}
+		}
+		if !reflect.DeepEqual(kept, tc.expected) {
+			t.Errorf("Expecting %+v to keep %v, got %v", tc.policy, tc.expected, kept)
+		}
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	testCases := map[string]time.Duration{
+		"72h":  72 * time.Hour,
+		"30d":  30 * 24 * time.Hour,
+		"4w":   4 * 7 * 24 * time.Hour,
+		"1h3m": time.Hour + 3*time.Minute,
+		"d":    -1,
+		"-3d":  -1,
+		"2y":   -1,
+	}
+	for value, expected := range testCases {
+		duration, err := parseRetention(value)
+		if (err != nil) != (expected < 0) || (err == nil && duration != expected) {
+			t.Errorf("Unexpected result for %q: %s, %v", value, duration, err)
+		}
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	memBackup(t, "mem://tests/prune/myTable/2019-01-01-02-00-00")
+	store, _ := memBackup(t, "mem://tests/prune/myTable/2019-01-02-02-00-00")
+	folder := &storage.FileInput{Bucket: aws.String("tests"), Path: aws.String("prune/myTable")}
+
+	var out bytes.Buffer
+	if err := pruneBackups(folder, store, retentionPolicy{last: 1}, true, "json", &out); err != nil {
+		t.Fatal(err)
+	}
+	decisions := []pruneDecision{}
+	if err := json.Unmarshal(out.Bytes(), &decisions); err != nil {
+		t.Fatalf("Invalid json output: %s\n%s", err, out.String())
+	}
+	if len(decisions) != 2 || !decisions[0].Keep || decisions[1].Keep {
+		t.Errorf("Unexpected decisions: %s", out.String())
+	}
+	if backups, _ := store.ListBackups(folder); len(backups) != 2 {
+		t.Errorf("Expecting the dry run to remove nothing, got %d backups left", len(backups))
+	}
+
+	out.Reset()
+	if err := pruneBackups(folder, store, retentionPolicy{last: 1}, false, "text", &out); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := store.ListBackups(folder)
+	if err != nil || len(backups) != 1 || backups[0].Time.Day() != 2 {
+		t.Errorf("Expecting only the newest backup to be left, got %+v, %v", backups, err)
+	}
+
+	// An incremental backup on top of the one left keeps it
+	var wg sync.WaitGroup
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	incremental, next, err := storage.Open("mem://tests/prune/myTable/2019-01-03-02-00-00", &storage.Config{DataPipe: dataPipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(1)
+	go incremental.Write(next, 1024, &wg)
+	close(dataPipe)
+	wg.Wait()
+	incremental.SetManifestInfo(storage.ManifestInfo{Incremental: &storage.Incremental{Parent: backups[0].URL, Attribute: "updated_at", Since: *backups[0].Time}})
+	if err = incremental.Commit(next, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = pruneBackups(folder, store, retentionPolicy{last: 1}, false, "text", &out); err != nil {
+		t.Fatal(err)
+	}
+	if backups, err = store.ListBackups(folder); err != nil || len(backups) != 2 {
+		t.Errorf("Expecting the parent of the incremental backup to be kept, got %+v, %v", backups, err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by goroutines to limit the number of
+// items (or capacity units) they process per second
+type rateLimiter struct {
+	mu sync.Mutex
+	// rate is the number of tokens added per second, no limit is applied if
+	// it is not positive
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing rate items per second with
+// bursts of up to burst items
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// newBatchRateLimiter returns a rateLimiter allowing batchSize items every
+// waitPeriod, which is what the -batch-size and -wait-ms options describe
+func newBatchRateLimiter(batchSize int64, waitPeriod time.Duration) *rateLimiter {
+	if waitPeriod <= 0 || batchSize <= 0 {
+		return newRateLimiter(0, 0)
+	}
+	return newRateLimiter(float64(batchSize)/waitPeriod.Seconds(), float64(batchSize))
+}
+
+// refill adds the tokens accumulated since the last call. It has to be called
+// with the lock held.
+func (l *rateLimiter) refill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// setRate changes the rate and the burst of the limiter. The tokens
+// accumulated so far are kept within the new burst.
+func (l *rateLimiter) setRate(rate, burst float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	l.rate = rate
+	l.burst = burst
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+}
+
+// currentRate returns the rate of the limiter
+func (l *rateLimiter) currentRate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// wait blocks until n items can be processed. The tokens are reserved right
+// away so that concurrent callers are served in order.
+func (l *rateLimiter) wait(n float64) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	l.refill()
+	l.tokens -= n
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(100, 10)
+	start := time.Now()
+	// The burst is available right away
+	limiter.wait(10)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("The burst should not wait, waited %s", elapsed)
+	}
+	// 10 more items need 100ms at 100 items per second
+	limiter.wait(10)
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Expecting to wait about 100ms, waited %s", elapsed)
+	}
+
+	unlimited := newBatchRateLimiter(100, 0)
+	start = time.Now()
+	unlimited.wait(1000000)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("No limit expected without wait period, waited %s", elapsed)
+	}
+}
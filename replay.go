@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// replayMargin is how long before the start of the restored backup the
+// archived changes are replayed from, as the clocks of the host making the
+// backup and of DynamoDB can differ. Replaying a change already in the backup
+// is harmless as all the following ones are replayed too.
+const replayMargin = time.Minute
+
+// changeSegments returns the complete segments of the change archive of the
+// given folder holding changes made between since and until, if not zero,
+// oldest first
+func changeSegments(store storage.BackupIface, folder *storage.FileInput, since, until time.Time) ([]storage.BackupInfo, error) {
+	backups, err := store.ListBackups(folder)
+	if err != nil {
+		return nil, err
+	}
+	segments := []storage.BackupInfo{}
+	var last time.Time
+	for _, backup := range backups {
+		if backup.Time == nil {
+			continue
+		}
+		if backup.Status != storage.StatusComplete {
+			log.Printf("Skipping the incomplete segment %s\n", backup.URL)
+			continue
+		}
+		manifest, err := store.ReadManifest(backup.Folder)
+		if err != nil {
+			return nil, err
+		}
+		if manifest == nil || manifest.Changes == nil {
+			return nil, fmt.Errorf("%s is not a segment of a change archive", backup.URL)
+		}
+		if manifest.Changes.Last.After(last) {
+			last = manifest.Changes.Last
+		}
+		if manifest.Changes.Last.Before(since) || (!until.IsZero() && manifest.Changes.First.After(until)) {
+			continue
+		}
+		segments = append(segments, backup)
+	}
+	if !until.IsZero() && last.Before(until) {
+		log.Printf("[WARNING] The changes are only archived until %s\n", last.Format(time.RFC3339))
+	}
+	return segments, nil
+}
+
+// replayChanges writes in the given table the changes of the archive of the
+// given location made from replayMargin before since and until the given time,
+// if not zero, in the order they were made. The changes of an item are written
+// by a single worker so that they are applied in order.
+func replayChanges(location string, cfg storage.Config, tableName string, since, until time.Time, batchSize int64, waitPeriod time.Duration, settings throughputSettings) error {
+	store, folder, err := storage.Open(location, &cfg)
+	if err != nil {
+		return err
+	}
+	since = since.Add(-replayMargin)
+	segments, err := changeSegments(store, folder, since, until)
+	if err != nil {
+		return fmt.Errorf("unable to list the segments of the change archive: %s", err)
+	}
+	throughput, err := settings.controller(dynamoSvc, tableName, true)
+	if err != nil {
+		return fmt.Errorf("unable to set up the throughput control: %s", err)
+	}
+
+	var replayed int64
+	for _, segment := range segments {
+		count, err := replaySegment(location, cfg, segment.Folder, tableName, since, until, batchSize, waitPeriod, throughput)
+		replayed += count
+		if err != nil {
+			return fmt.Errorf("while replaying %s: %s", segment.URL, err)
+		}
+	}
+	log.Printf("Replayed %d changes from %d segments of the change archive\n", replayed, len(segments))
+	return nil
+}
+
+// replaySegment writes in the given table the changes of the given segment
+// made between since and until, if not zero, and returns their number
+func replaySegment(location string, cfg storage.Config, segment *storage.FileInput, tableName string, since, until time.Time, batchSize int64, waitPeriod time.Duration, throughput *throughputController) (int64, error) {
+	var wg sync.WaitGroup
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	changes := make(chan map[string]*dynamodb.AttributeValue)
+	cfg.DataPipe = dataPipe
+	store, _, err := storage.Open(location, &cfg)
+	if err != nil {
+		return 0, err
+	}
+	if err = store.LoadManifest(&storage.FileInput{Bucket: segment.Bucket, Path: aws.String(fmt.Sprintf("%s/manifest", *segment.Path))}); err != nil {
+		return 0, err
+	}
+
+	writeErrs := make(chan error, 1)
+	go func() {
+		writeErrs <- ChannelToTable(dynamoSvc, tableName, batchSize, waitPeriod, 1, throughput, changes, nil, &wg)
+	}()
+	var replayed int64
+	go func() {
+		defer close(changes)
+		for item := range dataPipe {
+			change, _, err := storage.SplitChange(item)
+			if err == nil && change != nil && (change.Time.Before(since) || (!until.IsZero() && change.Time.After(until))) {
+				continue
+			}
+			replayed++
+			changes <- item
+		}
+	}()
+	if err = store.WriteToDB(tableName, batchSize, waitPeriod, &wg); err != nil {
+		// WriteToDB only closes the channel once all the files are read
+		close(dataPipe)
+	}
+	wg.Wait()
+	if writeErr := <-writeErrs; err == nil {
+		err = writeErr
+	}
+	return replayed, err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+func TestReplayChanges(t *testing.T) {
+	dynamoSvc = &mockStreamTableClient{viewType: dynamodb.StreamViewTypeNewImage}
+	svc := &mockStreamsClient{
+		shards: []*dynamodbstreams.Shard{{ShardId: aws.String("shard-1")}},
+		records: map[string][]*dynamodbstreams.Record{
+			"shard-1": {streamRecord("INSERT", "1", "Aerosmith", 1), streamRecord("MODIFY", "2", "Aerosmith", 2), streamRecord("INSERT", "3", "Queen", 3), streamRecord("REMOVE", "4", "Aerosmith", 4)},
+		},
+	}
+	location := "mem://tests/replay/myTable"
+	store, folder, err := storage.Open(location, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = archiveStream(svc, "myTable", location, storage.Config{}, time.Hour, 0, true, store, folder); err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Date(2019, 1, 1, 2, 3, 0, 0, time.UTC)
+	testCases := []struct {
+		until            time.Time
+		written, deleted []string
+	}{
+		// The changes of the minute before the backup are replayed too
+		{until: since.Add(30 * time.Second), written: []string{"Aerosmith 2", "Queen 3"}, deleted: []string{}},
+		{written: []string{"Queen 3"}, deleted: []string{"Aerosmith"}},
+		{until: since.Add(-2 * time.Minute), written: []string{}, deleted: []string{}},
+	}
+	for _, tc := range testCases {
+		mock := &mockDynamoDBClient{}
+		dynamoSvc = mock
+		if err = replayChanges(location, storage.Config{}, "myTable", since, tc.until, 25, 0, throughputSettings{}); err != nil {
+			t.Fatal(err)
+		}
+		written, deleted := []string{}, []string{}
+		for _, item := range mock.written {
+			if _, ok := item[storage.ChangeAttribute]; ok {
+				t.Errorf("Expecting the items to be written without their change, got %v", item)
+			}
+			written = append(written, *item["artist"].S+" "+*item["songs"].SS[0])
+		}
+		for _, key := range mock.deleted {
+			deleted = append(deleted, *key["artist"].S)
+		}
+		if !reflect.DeepEqual(written, tc.written) || !reflect.DeepEqual(deleted, tc.deleted) {
+			t.Errorf("Until %s, expecting %v written and %v deleted, got %v and %v", tc.until, tc.written, tc.deleted, written, deleted)
+		}
+	}
+}
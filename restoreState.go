@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// restoreStateFileName is the name of the file holding the progress of a
+// restore when it is saved in the backup folder
+const restoreStateFileName = "_RESTORE_STATE"
+
+// restoreStateInterval is the minimum time between 2 saves of the progress of
+// a restore, unless a manifest entry has been completed in the mean time
+const restoreStateInterval = 10 * time.Second
+
+// restoreStateSaver saves the progress of a restore, either in a local file or
+// in the backup folder, so that it can be resumed if interrupted
+type restoreStateSaver struct {
+	mu        sync.Mutex
+	store     storage.BackupIface
+	tableName string
+	// file is the location of the state in the store, used when no
+	// localFile is given
+	file      *storage.FileInput
+	localFile string
+	lastSave  time.Time
+	completed int
+}
+
+// newRestoreStateSaver returns a restoreStateSaver for the restore of the
+// given backup folder into the given table
+func newRestoreStateSaver(store storage.BackupIface, folder *storage.FileInput, tableName, localFile string) *restoreStateSaver {
+	return &restoreStateSaver{
+		store:     store,
+		tableName: tableName,
+		file:      &storage.FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *folder.Path, restoreStateFileName))},
+		localFile: localFile,
+		lastSave:  time.Now(),
+	}
+}
+
+// load returns the saved progress of the restore or nil if there is none
+func (s *restoreStateSaver) load() (*storage.RestoreState, error) {
+	var data []byte
+	if s.localFile != "" {
+		content, err := ioutil.ReadFile(s.localFile)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		data = content
+	} else {
+		if exists, err := s.store.Exists(s.file); err != nil || !exists {
+			return nil, err
+		}
+		doc, err := s.store.GetFile(s.file)
+		if err != nil {
+			return nil, err
+		}
+		defer storage.Close(*doc)
+		if data, err = ioutil.ReadAll(*doc); err != nil {
+			return nil, err
+		}
+	}
+	state := &storage.RestoreState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.TableName != s.tableName {
+		log.Printf("[WARNING] The saved restore state is for the table %s, ignoring it\n", state.TableName)
+		return nil, nil
+	}
+	return state, nil
+}
+
+// save writes the current progress of the restore
+func (s *restoreStateSaver) save() error {
+	state := s.store.RestoreState()
+	s.lastSave = time.Now()
+	s.completed = len(state.Completed)
+	return s.write(state)
+}
+
+// finish records that the restore is complete, so that it is skipped when the
+// restore of the chain of backups it belongs to is resumed
+func (s *restoreStateSaver) finish() error {
+	return s.write(storage.RestoreState{Done: true})
+}
+
+// write writes the given state of the restore
+func (s *restoreStateSaver) write(state storage.RestoreState) error {
+	state.TableName = s.tableName
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if s.localFile != "" {
+		return ioutil.WriteFile(s.localFile, data, 0644)
+	}
+	return s.store.Flush(s.file, data)
+}
+
+// ack acknowledges the items written in the table and saves the progress of
+// the restore if a manifest entry has been completed or after some time
+func (s *restoreStateSaver) ack(first, count int64) {
+	s.store.Acknowledge(first, count)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastSave) < restoreStateInterval && len(s.store.RestoreState().Completed) == s.completed {
+		return
+	}
+	if err := s.save(); err != nil {
+		log.Printf("[WARNING] Unable to save the progress of the restore: %s\n", err)
+	}
+}
+
+// clear removes the saved progress once the restore is complete
+func (s *restoreStateSaver) clear() error {
+	if s.localFile != "" {
+		if err := os.Remove(s.localFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return s.store.Delete(s.file)
+}
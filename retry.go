@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// retryableCodes are the error codes of the DynamoDB and DynamoDB Streams calls that are retried
+// on top of the ones the AWS SDK considers as throttling or retryable
+var retryableCodes = map[string]bool{
+	dynamodb.ErrCodeProvisionedThroughputExceededException: true,
+	dynamodb.ErrCodeRequestLimitExceeded:                   true,
+	dynamodb.ErrCodeInternalServerError:                    true,
+	dynamodbstreams.ErrCodeLimitExceededException:          true,
+	"ThrottlingException":                                  true,
+	"ServiceUnavailable":                                   true,
+}
+
+// retryPolicy describes how the failed DynamoDB calls are retried: the delay
+// before each retry is picked at random between 0 and an exponentially growing
+// cap (full jitter)
+type retryPolicy struct {
+	// baseDelay is the cap of the delay before the 1st retry, doubled for
+	// each following one up to maxDelay
+	baseDelay, maxDelay time.Duration
+	// maxAttempts is the number of calls made before giving up and
+	// maxElapsed the time after which no more retries are done. There is no
+	// limit when they are not positive.
+	maxAttempts int
+	maxElapsed  time.Duration
+}
+
+// retry is the retry policy used for all the DynamoDB calls
+var retry = retryPolicy{
+	baseDelay:   100 * time.Millisecond,
+	maxDelay:    20 * time.Second,
+	maxAttempts: 10,
+	maxElapsed:  5 * time.Minute,
+}
+
+// isRetryable returns whether the given error is a transient error of a
+// DynamoDB call
+func isRetryable(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	return retryableCodes[aerr.Code()] || request.IsErrorThrottle(err) || request.IsErrorRetryable(err)
+}
+
+// delay returns the delay to wait before the given retry, starting at 1
+func (p retryPolicy) delay(attempt int) time.Duration {
+	limit := p.baseDelay
+	for i := 1; i < attempt && limit < p.maxDelay; i++ {
+		limit *= 2
+	}
+	if limit > p.maxDelay {
+		limit = p.maxDelay
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// backoff keeps track of the consecutive failures of an operation
+type backoff struct {
+	policy   retryPolicy
+	attempts int
+	start    time.Time
+}
+
+// newBackoff returns a backoff for an operation starting now
+func (p retryPolicy) newBackoff() *backoff {
+	return &backoff{policy: p, start: time.Now()}
+}
+
+// reset is called once the operation has made some progress so that the
+// limits apply to the next failures only
+func (b *backoff) reset() {
+	b.attempts = 0
+	b.start = time.Now()
+}
+
+// retry waits before the next attempt of the operation if the given error is
+// retryable and the limits of the policy are not reached. Otherwise it returns
+// the error, which is nil if err is nil.
+func (b *backoff) retry(err error) error {
+	if err == nil {
+		return nil
+	}
+	if !isRetryable(err) {
+		return err
+	}
+	b.attempts++
+	if b.policy.maxAttempts > 0 && b.attempts >= b.policy.maxAttempts {
+		return fmt.Errorf("giving up after %d attempts: %s", b.attempts, err)
+	}
+	wait := b.policy.delay(b.attempts)
+	if b.policy.maxElapsed > 0 && time.Since(b.start)+wait > b.policy.maxElapsed {
+		return fmt.Errorf("giving up after %s: %s", time.Since(b.start).Round(time.Millisecond), err)
+	}
+	log.Printf("[WARNING] Attempt %d failed, will wait %s before retrying: %s\n", b.attempts, wait, err)
+	time.Sleep(wait)
+	return nil
+}
+
+// do calls fn until it succeeds or returns an error that can't be retried
+func (p retryPolicy) do(fn func() error) error {
+	b := p.newBackoff()
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if err = b.retry(err); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// testRetryPolicy is a retry policy waiting no more than a millisecond
+var testRetryPolicy = retryPolicy{baseDelay: time.Microsecond, maxDelay: time.Millisecond, maxAttempts: 4}
+
+func TestIsRetryable(t *testing.T) {
+	errorTest := []struct {
+		err      error
+		expected bool
+	}{
+		{err: fmt.Errorf("Random error"), expected: false},
+		{err: awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Bla bla", nil), expected: false},
+		{err: awserr.New("ValidationException", "Bla bla", nil), expected: false},
+		{err: awserr.New(dynamodb.ErrCodeInternalServerError, "Bla bla", nil), expected: true},
+		{err: awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "Bla bla", nil), expected: true},
+		{err: awserr.New(dynamodb.ErrCodeRequestLimitExceeded, "Bla bla", nil), expected: true},
+		{err: awserr.New("ThrottlingException", "Bla bla", nil), expected: true},
+	}
+	for _, item := range errorTest {
+		if isRetryable(item.err) != item.expected {
+			t.Errorf("Expecting %v to be retryable: %t", item.err, item.expected)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+	for _, item := range []struct {
+		attempt int
+		limit   time.Duration
+	}{{1, 100 * time.Millisecond}, {2, 200 * time.Millisecond}, {4, 800 * time.Millisecond}, {10, time.Second}} {
+		for i := 0; i < 100; i++ {
+			if delay := policy.delay(item.attempt); delay < 0 || delay >= item.limit {
+				t.Fatalf("Expecting the delay of attempt %d to be below %s, got %s", item.attempt, item.limit, delay)
+			}
+		}
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	throttled := awserr.New("ThrottlingException", "Slow down", nil)
+
+	calls := 0
+	err := testRetryPolicy.do(func() error {
+		calls++
+		if calls < 3 {
+			return throttled
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expecting success after 3 calls, got %d calls and error %v", calls, err)
+	}
+
+	calls = 0
+	err = testRetryPolicy.do(func() error {
+		calls++
+		return throttled
+	})
+	if err == nil || err.Error() != fmt.Sprintf("giving up after %d attempts: %s", testRetryPolicy.maxAttempts, throttled) || calls != testRetryPolicy.maxAttempts {
+		t.Errorf("Expecting to give up after %d calls, got %d calls and error %v", testRetryPolicy.maxAttempts, calls, err)
+	}
+
+	calls = 0
+	validation := awserr.New("ValidationException", "Bad request", nil)
+	if err = testRetryPolicy.do(func() error {
+		calls++
+		return validation
+	}); err != validation || calls != 1 {
+		t.Errorf("Expecting no retry of a non retryable error, got %d calls and error %v", calls, err)
+	}
+
+	elapsed := retryPolicy{baseDelay: 10 * time.Millisecond, maxDelay: 10 * time.Millisecond, maxElapsed: 25 * time.Millisecond}
+	start := time.Now()
+	if err = elapsed.do(func() error { return throttled }); err == nil || !strings.HasSuffix(err.Error(), throttled.Error()) {
+		t.Errorf("Expecting to give up, got %v", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("Expecting to give up after 25ms, took %s", time.Since(start))
+	}
+}
+
+// struct to mock BatchWriteItem calls failing according to a script
+type mockFlakyDynamoDBClient struct {
+	dynamodbiface.DynamoDBAPI
+	mu sync.Mutex
+	// failures are the errors returned by the first calls
+	failures []error
+	// unprocessed is the number of calls returning their last item as
+	// unprocessed once the failures are returned
+	unprocessed int
+	written     int
+}
+
+func (m *mockFlakyDynamoDBClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.failures) > 0 {
+		err := m.failures[0]
+		m.failures = m.failures[1:]
+		return nil, err
+	}
+	output := &dynamodb.BatchWriteItemOutput{ConsumedCapacity: []*dynamodb.ConsumedCapacity{{CapacityUnits: aws.Float64(1)}}}
+	for table, requests := range input.RequestItems {
+		if m.unprocessed > 0 {
+			m.unprocessed--
+			output.UnprocessedItems = map[string][]*dynamodb.WriteRequest{table: requests[len(requests)-1:]}
+			requests = requests[:len(requests)-1]
+		}
+		m.written += len(requests)
+	}
+	return output, nil
+}
+
+func TestBatchToTableRetries(t *testing.T) {
+	defer func(policy retryPolicy) { retry = policy }(retry)
+	retry = testRetryPolicy
+
+	requests := []*dynamodb.WriteRequest{}
+	for _, item := range dataSet {
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+	svc := &mockFlakyDynamoDBClient{
+		failures:    []error{awserr.New("ThrottlingException", "Slow down", nil), awserr.New(dynamodb.ErrCodeInternalServerError, "Oops", nil)},
+		unprocessed: 2,
+	}
+	capacity, err := batchToTable(svc, map[string][]*dynamodb.WriteRequest{"myTable": requests})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if svc.written != len(dataSet) {
+		t.Errorf("Expecting %d items written, got %d", len(dataSet), svc.written)
+	}
+	if capacity != 3 {
+		t.Errorf("Expecting 3 capacity units consumed, got %f", capacity)
+	}
+
+	svc = &mockFlakyDynamoDBClient{failures: []error{awserr.New("ValidationException", "Bad request", nil)}}
+	if _, err = batchToTable(svc, map[string][]*dynamodb.WriteRequest{"myTable": requests}); err == nil {
+		t.Errorf("Expecting an error for a non retryable failure")
+	}
+}
+
+func TestChannelToTableFailure(t *testing.T) {
+	defer func(policy retryPolicy) { retry = policy }(retry)
+	retry = testRetryPolicy
+
+	var wg sync.WaitGroup
+	svc := &mockFlakyDynamoDBClient{failures: []error{awserr.New("ValidationException", "Bad request", nil)}}
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	errc := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		errc <- ChannelToTable(svc, "myTable", 1, 0, 1, nil, dataPipe, nil, &wg)
+	}()
+	// All the items are consumed even after the failure
+	for _, item := range dataSet {
+		dataPipe <- item
+	}
+	close(dataPipe)
+	wg.Wait()
+	if err := <-errc; err == nil {
+		t.Errorf("Expecting the error of the batch write")
+	}
+	if svc.written != 0 {
+		t.Errorf("Expecting no item written after the failure, got %d", svc.written)
+	}
+}
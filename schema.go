@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// schemaFileName is the name of the file holding the TableSchema, stored next
+// to the manifest of the backup
+const schemaFileName = "schema.json"
+
+// TableSchema holds everything needed to recreate a table before restoring a
+// backup in it
+type TableSchema struct {
+	Table      *dynamodb.TableDescription      `json:"table"`
+	TimeToLive *dynamodb.TimeToLiveDescription `json:"timeToLive,omitempty"`
+	Tags       []*dynamodb.Tag                 `json:"tags,omitempty"`
+}
+
+// DescribeTableSchema retrieves the description, the TTL setting and the tags
+// of the given table. Failing to retrieve the TTL setting or the tags is not
+// considered as an error as they are not required to recreate the table.
+func DescribeTableSchema(svc dynamodbiface.DynamoDBAPI, tableName string) (*TableSchema, error) {
+	var result *dynamodb.DescribeTableOutput
+	err := retry.do(func() (err error) {
+		result, err = svc.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	schema := &TableSchema{Table: result.Table}
+
+	ttl, err := svc.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	if err != nil {
+		log.Printf("[WARNING] Unable to retrieve the TTL setting of %s: %s\n", tableName, err)
+	} else {
+		schema.TimeToLive = ttl.TimeToLiveDescription
+	}
+
+	if schema.Tags, err = tableTags(svc, result.Table.TableArn); err != nil {
+		log.Printf("[WARNING] Unable to retrieve the tags of %s: %s\n", tableName, err)
+	}
+	return schema, nil
+}
+
+// tableTags returns all the tags of the table of the given ARN
+func tableTags(svc dynamodbiface.DynamoDBAPI, arn *string) ([]*dynamodb.Tag, error) {
+	var tags []*dynamodb.Tag
+	input := &dynamodb.ListTagsOfResourceInput{ResourceArn: arn}
+	for {
+		var output *dynamodb.ListTagsOfResourceOutput
+		err := retry.do(func() (err error) {
+			output, err = svc.ListTagsOfResource(input)
+			return err
+		})
+		if err != nil {
+			return tags, err
+		}
+		tags = append(tags, output.Tags...)
+		if output.NextToken == nil {
+			return tags, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// CreateTableInput builds the input of a CreateTable call that recreates the
+// table described by the schema under the given name
+func (s *TableSchema) CreateTableInput(tableName string) *dynamodb.CreateTableInput {
+	tbl := s.Table
+	input := &dynamodb.CreateTableInput{
+		TableName:            aws.String(tableName),
+		AttributeDefinitions: tbl.AttributeDefinitions,
+		KeySchema:            tbl.KeySchema,
+		BillingMode:          aws.String(dynamodb.BillingModeProvisioned),
+	}
+	if tbl.BillingModeSummary != nil && tbl.BillingModeSummary.BillingMode != nil {
+		input.BillingMode = tbl.BillingModeSummary.BillingMode
+	}
+	provisioned := *input.BillingMode == dynamodb.BillingModeProvisioned
+	if provisioned {
+		input.ProvisionedThroughput = throughputFromDescription(tbl.ProvisionedThroughput)
+	}
+
+	for _, gsi := range tbl.GlobalSecondaryIndexes {
+		index := &dynamodb.GlobalSecondaryIndex{
+			IndexName:  gsi.IndexName,
+			KeySchema:  gsi.KeySchema,
+			Projection: gsi.Projection,
+		}
+		if provisioned {
+			index.ProvisionedThroughput = throughputFromDescription(gsi.ProvisionedThroughput)
+		}
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, index)
+	}
+	for _, lsi := range tbl.LocalSecondaryIndexes {
+		input.LocalSecondaryIndexes = append(input.LocalSecondaryIndexes, &dynamodb.LocalSecondaryIndex{
+			IndexName:  lsi.IndexName,
+			KeySchema:  lsi.KeySchema,
+			Projection: lsi.Projection,
+		})
+	}
+
+	if sse := tbl.SSEDescription; sse != nil && aws.StringValue(sse.Status) == dynamodb.SSEStatusEnabled {
+		input.SSESpecification = &dynamodb.SSESpecification{
+			Enabled:        aws.Bool(true),
+			SSEType:        sse.SSEType,
+			KMSMasterKeyId: sse.KMSMasterKeyArn,
+		}
+	}
+	if tbl.StreamSpecification != nil && aws.BoolValue(tbl.StreamSpecification.StreamEnabled) {
+		input.StreamSpecification = tbl.StreamSpecification
+	}
+	// The tags with the reserved aws: prefix can't be set by the users
+	for _, tag := range s.Tags {
+		if !strings.HasPrefix(aws.StringValue(tag.Key), "aws:") {
+			input.Tags = append(input.Tags, tag)
+		}
+	}
+	return input
+}
+
+// throughputFromDescription converts the provisioned throughput of a table
+// description to a provisioned throughput usable at table creation
+func throughputFromDescription(desc *dynamodb.ProvisionedThroughputDescription) *dynamodb.ProvisionedThroughput {
+	throughput := &dynamodb.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(1), WriteCapacityUnits: aws.Int64(1)}
+	if desc != nil {
+		if aws.Int64Value(desc.ReadCapacityUnits) > 0 {
+			throughput.ReadCapacityUnits = desc.ReadCapacityUnits
+		}
+		if aws.Int64Value(desc.WriteCapacityUnits) > 0 {
+			throughput.WriteCapacityUnits = desc.WriteCapacityUnits
+		}
+	}
+	return throughput
+}
+
+// CreateTableFromSchema creates the given table from the schema, waits for it
+// to be ACTIVE and then applies the TTL setting of the schema
+func CreateTableFromSchema(svc dynamodbiface.DynamoDBAPI, tableName string, schema *TableSchema) error {
+	if _, err := svc.CreateTable(schema.CreateTableInput(tableName)); err != nil {
+		return err
+	}
+	log.Printf("Waiting for the table %s to be ACTIVE\n", tableName)
+	if err := svc.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(tableName)}); err != nil {
+		return err
+	}
+
+	if ttl := schema.TimeToLive; ttl != nil && ttl.AttributeName != nil {
+		switch aws.StringValue(ttl.TimeToLiveStatus) {
+		case dynamodb.TimeToLiveStatusEnabled, dynamodb.TimeToLiveStatusEnabling:
+			_, err := svc.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+				TableName: aws.String(tableName),
+				TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+					AttributeName: ttl.AttributeName,
+					Enabled:       aws.Bool(true),
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("unable to enable the TTL on %s: %s", *ttl.AttributeName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// backupSchema saves the schema of the given table in the backup folder
+func backupSchema(svc dynamodbiface.DynamoDBAPI, tableName string, folder *storage.FileInput, store storage.BackupIface) error {
+	schema, err := DescribeTableSchema(svc, tableName)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	return store.Flush(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *folder.Path, schemaFileName))}, data)
+}
+
+// loadSchema reads the schema saved in the backup folder
+func loadSchema(folder *storage.FileInput, store storage.BackupIface) (*TableSchema, error) {
+	doc, err := store.GetFile(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *folder.Path, schemaFileName))})
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close(*doc)
+	data, err := ioutil.ReadAll(*doc)
+	if err != nil {
+		return nil, err
+	}
+	schema := &TableSchema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, err
+	}
+	if schema.Table == nil {
+		return nil, fmt.Errorf("no table description found in the schema")
+	}
+	return schema, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// testTableDescription is the description of the table used for the schema tests
+var testTableDescription = &dynamodb.TableDescription{
+	TableName:            aws.String("myTable"),
+	TableArn:             aws.String("arn:aws:dynamodb:us-east-1:123456789012:table/myTable"),
+	AttributeDefinitions: []*dynamodb.AttributeDefinition{{AttributeName: aws.String("artist"), AttributeType: aws.String("S")}},
+	KeySchema:            []*dynamodb.KeySchemaElement{{AttributeName: aws.String("artist"), KeyType: aws.String("HASH")}},
+	ProvisionedThroughput: &dynamodb.ProvisionedThroughputDescription{
+		ReadCapacityUnits:  aws.Int64(10),
+		WriteCapacityUnits: aws.Int64(5),
+	},
+	GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndexDescription{{
+		IndexName:             aws.String("byArtist"),
+		KeySchema:             []*dynamodb.KeySchemaElement{{AttributeName: aws.String("artist"), KeyType: aws.String("HASH")}},
+		Projection:            &dynamodb.Projection{ProjectionType: aws.String("KEYS_ONLY")},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughputDescription{ReadCapacityUnits: aws.Int64(3), WriteCapacityUnits: aws.Int64(2)},
+	}},
+	SSEDescription:      &dynamodb.SSEDescription{Status: aws.String("ENABLED"), SSEType: aws.String("KMS"), KMSMasterKeyArn: aws.String("arn:aws:kms:key")},
+	StreamSpecification: &dynamodb.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: aws.String("NEW_IMAGE")},
+	TableStatus:         aws.String("ACTIVE"),
+}
+
+// struct to mock the Dynamo calls related to the table schema
+type mockSchemaDynamoDBClient struct {
+	dynamodbiface.DynamoDBAPI
+	created    *dynamodb.CreateTableInput
+	ttlUpdated *dynamodb.UpdateTimeToLiveInput
+}
+
+func (m *mockSchemaDynamoDBClient) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	return &dynamodb.DescribeTableOutput{Table: testTableDescription}, nil
+}
+
+func (m *mockSchemaDynamoDBClient) DescribeTimeToLive(input *dynamodb.DescribeTimeToLiveInput) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: &dynamodb.TimeToLiveDescription{AttributeName: aws.String("expires"), TimeToLiveStatus: aws.String("ENABLED")}}, nil
+}
+
+func (m *mockSchemaDynamoDBClient) ListTagsOfResource(input *dynamodb.ListTagsOfResourceInput) (*dynamodb.ListTagsOfResourceOutput, error) {
+	if input.NextToken == nil {
+		return &dynamodb.ListTagsOfResourceOutput{Tags: []*dynamodb.Tag{{Key: aws.String("team"), Value: aws.String("music")}}, NextToken: aws.String("next")}, nil
+	}
+	return &dynamodb.ListTagsOfResourceOutput{Tags: []*dynamodb.Tag{{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("music")}}}, nil
+}
+
+func (m *mockSchemaDynamoDBClient) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	m.created = input
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (m *mockSchemaDynamoDBClient) WaitUntilTableExists(input *dynamodb.DescribeTableInput) error {
+	return nil
+}
+
+func (m *mockSchemaDynamoDBClient) UpdateTimeToLive(input *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	m.ttlUpdated = input
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func TestCreateTableFromSchema(t *testing.T) {
+	svc := &mockSchemaDynamoDBClient{}
+	schema, err := DescribeTableSchema(svc, "myTable")
+	if err != nil {
+		t.Fatalf("Unexpected error describing the table: %s", err)
+	}
+	if len(schema.Tags) != 2 {
+		t.Errorf("Expecting the tags of all the pages, got: %v", schema.Tags)
+	}
+
+	if err = CreateTableFromSchema(svc, "myRestoredTable", schema); err != nil {
+		t.Fatalf("Unexpected error creating the table: %s", err)
+	}
+	expected := &dynamodb.CreateTableInput{
+		TableName:             aws.String("myRestoredTable"),
+		AttributeDefinitions:  testTableDescription.AttributeDefinitions,
+		KeySchema:             testTableDescription.KeySchema,
+		BillingMode:           aws.String("PROVISIONED"),
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(10), WriteCapacityUnits: aws.Int64(5)},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{{
+			IndexName:             aws.String("byArtist"),
+			KeySchema:             testTableDescription.GlobalSecondaryIndexes[0].KeySchema,
+			Projection:            testTableDescription.GlobalSecondaryIndexes[0].Projection,
+			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(3), WriteCapacityUnits: aws.Int64(2)},
+		}},
+		SSESpecification:    &dynamodb.SSESpecification{Enabled: aws.Bool(true), SSEType: aws.String("KMS"), KMSMasterKeyId: aws.String("arn:aws:kms:key")},
+		StreamSpecification: testTableDescription.StreamSpecification,
+		Tags:                []*dynamodb.Tag{{Key: aws.String("team"), Value: aws.String("music")}},
+	}
+	if !reflect.DeepEqual(svc.created, expected) {
+		t.Errorf("CreateTable input mismatch. Expecting: %v\nGot: %v", expected, svc.created)
+	}
+	if svc.ttlUpdated == nil || *svc.ttlUpdated.TimeToLiveSpecification.AttributeName != "expires" {
+		t.Errorf("Expecting the TTL to be enabled on the expires attribute, got: %v", svc.ttlUpdated)
+	}
+}
+
+func TestCreateTableInputOnDemand(t *testing.T) {
+	schema := &TableSchema{Table: &dynamodb.TableDescription{
+		AttributeDefinitions: testTableDescription.AttributeDefinitions,
+		KeySchema:            testTableDescription.KeySchema,
+		BillingModeSummary:   &dynamodb.BillingModeSummary{BillingMode: aws.String("PAY_PER_REQUEST")},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndexDescription{{
+			IndexName:  aws.String("byArtist"),
+			KeySchema:  testTableDescription.GlobalSecondaryIndexes[0].KeySchema,
+			Projection: testTableDescription.GlobalSecondaryIndexes[0].Projection,
+		}},
+	}}
+	input := schema.CreateTableInput("myTable")
+	if *input.BillingMode != "PAY_PER_REQUEST" || input.ProvisionedThroughput != nil || input.GlobalSecondaryIndexes[0].ProvisionedThroughput != nil {
+		t.Errorf("No provisioned throughput expected for an on-demand table. Got: %v", input)
+	}
+}
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// fileStore holds the basic file operations that each storage backend has to
+// provide so that the generic backup and restore logic of backupBase can work
+// with it
+type fileStore interface {
+	GetFile(*FileInput) (*io.ReadCloser, error)
+	Exists(*FileInput) (bool, error)
+	Flush(*FileInput, []byte) error
+	Delete(*FileInput) error
+	// move renames the given file, replacing the destination if it exists
+	move(from, to *FileInput) error
+	// ListFolders returns the sorted names of the direct sub-folders of the
+	// given folder
+	ListFolders(*FileInput) ([]string, error)
+	// ListFiles returns the files found under the given folder, at any depth
+	ListFiles(*FileInput) ([]*FileInput, error)
+	// removeFolder removes what is left of the given folder once all its
+	// files are deleted, for the backends having actual folders
+	removeFolder(*FileInput) error
+	// fileURL returns the URL of the given file as written in the manifest
+	fileURL(*FileInput) string
+	// urlToFileInput translates a manifest entry URL into a FileInput. It
+	// returns nil if the URL is not handled by the backend.
+	urlToFileInput(*url.URL) *FileInput
+}
+
+// maxLineSize is the maximum size of a line of a data file. A DynamoDB item
+// is 400KB max but its json representation can be bigger.
+const maxLineSize = 4 * 1024 * 1024
+
+// newLineScanner returns a scanner reading the lines of a data file
+func newLineScanner(reader io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	return scanner
+}
+
+// backupBase holds the logic shared by all the storage backends. Each backend
+// embeds it and sets store to itself.
+type backupBase struct {
+	manifest     Manifest
+	store        fileStore
+	compression  string
+	checkpoint   *Checkpoint
+	checkpointer Checkpointer
+	// staging is set when the data files are written in the stagingFolder
+	// until the backup is committed
+	staging bool
+	// items is the number of items written in the data files by Write
+	items        int64
+	progress     *restoreProgress
+	progressOnce sync.Once
+	DataPipe     chan map[string]*dynamodb.AttributeValue
+}
+
+// configure applies the given Config to the backend
+func (h *backupBase) configure(cfg *Config) {
+	h.DataPipe = cfg.DataPipe
+	h.checkpointer = cfg.Checkpointer
+	h.staging = cfg.Staging
+	if cfg.Compression != CompressionNone {
+		h.compression = cfg.Compression
+	}
+}
+
+// LoadManifest downloads the given manifest file and load it in the
+// Manifest attribute of the struct
+func (h *backupBase) LoadManifest(input *FileInput) error {
+	doc, err := h.store.GetFile(input)
+	if err != nil {
+		log.Printf("[ERROR] Unable to retrieve the manifest flag information: %s\nAborting...\n", err)
+		return err
+	}
+	defer Close(*doc)
+	buff := bytes.NewBuffer(nil)
+	if _, err := io.Copy(buff, *doc); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(buff.Bytes(), &h.manifest)
+}
+
+// readJSONFile decodes the given json file into v. It returns false if the
+// file does not exist.
+func (h *backupBase) readJSONFile(input *FileInput, v interface{}) (bool, error) {
+	if exists, err := h.store.Exists(input); err != nil || !exists {
+		return false, err
+	}
+	doc, err := h.store.GetFile(input)
+	if err != nil {
+		return false, err
+	}
+	defer Close(*doc)
+	data, err := ioutil.ReadAll(*doc)
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Scan reads the data from a backup line by line, serializes it and
+// sends it to the struct's channel. Compressed data is decompressed using the
+// compression of the manifest or, if absent, the one detected from the data.
+func (h *backupBase) Scan(dataReader *io.ReadCloser) error {
+	return h.scanEntry(dataReader, nil, 0)
+}
+
+// scanEntry does the same as Scan but skips the first skipLines lines and
+// reports the progress of the given manifest entry if not nil
+func (h *backupBase) scanEntry(dataReader *io.ReadCloser, entry *entryProgress, skipLines int64) error {
+	defer Close(*dataReader)
+	reader, err := decompressReader(h.manifest.Compression, *dataReader)
+	if err != nil {
+		return err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer Close(closer)
+	}
+	scanner := newLineScanner(reader)
+	var line int64
+	for scanner.Scan() {
+		line++
+		if line <= skipLines {
+			continue
+		}
+		data := scanner.Bytes()
+		res, err := UnmarshalDynamoAttributeMap(data)
+		if err != nil {
+			log.Printf("[Error] unmashaling %v: %s", data, err)
+			if entry != nil {
+				h.progress.invalidLine(entry)
+			}
+		} else {
+			if entry != nil {
+				h.progress.itemSent(entry)
+			}
+			h.DataPipe <- res
+		}
+	}
+	return scanner.Err()
+}
+
+// WriteToDB pulls the files listed in the manifest and import them inside the
+// given table using the given batch size (and wait period between each batch).
+// The entries already written according to the state given to ResumeRestore
+// are skipped.
+func (h *backupBase) WriteToDB(tableName string, batchSize int64, waitPeriod time.Duration, wg *sync.WaitGroup) error {
+	wg.Add(1)
+	progress := h.restoreProgress()
+	for _, entry := range h.manifest.Entries {
+		u, err := url.Parse(entry.URL)
+		if err != nil {
+			return err
+		}
+		if input := h.store.urlToFileInput(u); input != nil {
+			skipLines := progress.skipLines(entry.URL)
+			if skipLines < 0 {
+				log.Printf("Skipping %s, already restored\n", entry.URL)
+				continue
+			}
+			data, err := h.store.GetFile(input)
+			if err != nil {
+				return err
+			}
+			current := progress.startEntry(entry.URL, skipLines)
+			if err = h.scanEntry(data, current, skipLines); err != nil {
+				return err
+			}
+			progress.endEntry(current)
+		}
+	}
+	close(h.DataPipe)
+	return nil
+}
+
+// DumpBuffer dumps the content of the given buffer to a new randomly generated
+// file name in the given folder (or in its staging folder) and resets the said
+// buffer. The file is only added to the manifest if it has been written.
+func (h *backupBase) DumpBuffer(input *FileInput, buff *bytes.Buffer) error {
+	defer buff.Reset()
+	data, extension, err := compressData(h.compression, buff.Bytes())
+	if err != nil {
+		return fmt.Errorf("while compressing the data using %s: %s", h.compression, err)
+	}
+	folder := *input.Path
+	if h.staging {
+		folder = fmt.Sprintf("%s/%s", folder, stagingFolder)
+	}
+	file := &FileInput{Bucket: input.Bucket, Path: aws.String(fmt.Sprintf("%s/%s%s", folder, genNewFileName(), extension))}
+	items := int64(bytes.Count(buff.Bytes(), []byte("\n")))
+	if err := h.store.Flush(file, data); err != nil {
+		return fmt.Errorf("while writing the file %s: %s", h.store.fileURL(file), err)
+	}
+	checksum := sha256.Sum256(data)
+	h.manifest.Entries = append(h.manifest.Entries, ManifestEntry{
+		URL:       h.store.fileURL(file),
+		Mandatory: true,
+		SHA256:    hex.EncodeToString(checksum[:]),
+		Size:      int64(len(data)),
+		Items:     items,
+	})
+	return nil
+}
+
+// Write reads from the struct's channel and sends the data to the given folder
+// in files of bufferSize max size. A checkpoint is saved after each file so
+// that the backup can be resumed using LoadCheckpoint if interrupted.
+// After a failure, the channel is still read until closed but nothing is
+// written anymore and the error is returned. The backup then has to be
+// completed by Commit.
+func (h *backupBase) Write(input *FileInput, bufferSize int, wg *sync.WaitGroup) error {
+	defer wg.Done()
+	// buff is the buffer where the data will be stored while before being flushed
+	var buff bytes.Buffer
+	var failure error
+	// buffered is the number of items in the buffer
+	var buffered int64
+	h.items = 0
+	start := time.Now().UTC()
+	h.manifest = Manifest{Version: 3, Name: "DynamoDB-export", ManifestInfo: ManifestInfo{Start: &start}}
+	if _, ok := codecs[h.compression]; ok {
+		h.manifest.Compression = h.compression
+	}
+	if h.checkpoint != nil {
+		h.manifest.Entries = h.checkpoint.Entries
+		h.items = h.checkpoint.Items
+		if h.checkpoint.Start != nil {
+			h.manifest.Start = h.checkpoint.Start
+		}
+	}
+
+	for elem := range h.DataPipe {
+		if failure != nil {
+			// Drains the channel so that its producer is not blocked
+			continue
+		}
+		data, err := MarshalDynamoAttributeMap(elem)
+		if err != nil {
+			failure = fmt.Errorf("while converting to json: %v\nError: %s", elem, err)
+			log.Printf("[ERROR] %s\n", failure)
+			continue
+		}
+
+		// before overflowing the buffer, dump it and empty it
+		if buff.Len()+len(data) >= bufferSize && buff.Len() > 0 {
+			if failure = h.DumpBuffer(input, &buff); failure != nil {
+				log.Printf("[ERROR] %s\n", failure)
+				continue
+			}
+			h.items += buffered
+			buffered = 0
+			h.saveCheckpoint(input, h.items)
+		}
+		// add the data to the buffer
+		buff.Write(data)
+		buff.WriteString("\n")
+		buffered++
+	}
+	if failure != nil {
+		return failure
+	}
+
+	// Upload the rest of the buffer
+	if err := h.DumpBuffer(input, &buff); err != nil {
+		log.Printf("[ERROR] %s\n", err)
+		return err
+	}
+	h.items += buffered
+	h.saveCheckpoint(input, h.items)
+	return nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ChangeAttribute is the reserved attribute of the items of a change archive
+// holding the Change each of them results from
+const ChangeAttribute = "_dynamodbdump_change"
+
+// Events of the changes, named after the ones of the DynamoDB Streams records
+const (
+	EventInsert = "INSERT"
+	EventModify = "MODIFY"
+	EventRemove = "REMOVE"
+)
+
+// Change describes the change an item of a change archive results from. The
+// item is the new image of the item for EventInsert and EventModify and its
+// key for EventRemove.
+type Change struct {
+	Event string
+	Time  time.Time
+	// Sequence is the sequence number of the stream record of the change
+	Sequence string
+	// Keys are the names of the key attributes of the item
+	Keys []string
+}
+
+// ChangeSet describes the changes held by a backup of a change archive. It is
+// recorded in its manifest.
+type ChangeSet struct {
+	StreamARN string `json:"streamArn"`
+	// First and Last are the times of the first and of the last change
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// MarkChange returns a copy of the given item holding the given change in its
+// ChangeAttribute
+func MarkChange(item map[string]*dynamodb.AttributeValue, change Change) map[string]*dynamodb.AttributeValue {
+	marked := make(map[string]*dynamodb.AttributeValue, len(item)+1)
+	for name, value := range item {
+		marked[name] = value
+	}
+	marked[ChangeAttribute] = &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{
+		"event":    {S: aws.String(change.Event)},
+		"time":     {S: aws.String(change.Time.UTC().Format(time.RFC3339Nano))},
+		"sequence": {S: aws.String(change.Sequence)},
+	}}
+	if len(change.Keys) > 0 {
+		keys := append([]string{}, change.Keys...)
+		sort.Strings(keys)
+		marked[ChangeAttribute].M["keys"] = &dynamodb.AttributeValue{SS: aws.StringSlice(keys)}
+	}
+	return marked
+}
+
+// SplitChange returns the change held by the given item along with the item
+// without its ChangeAttribute. The change is nil if the item holds none.
+func SplitChange(item map[string]*dynamodb.AttributeValue) (*Change, map[string]*dynamodb.AttributeValue, error) {
+	value, ok := item[ChangeAttribute]
+	if !ok {
+		return nil, item, nil
+	}
+	if value.M == nil || value.M["event"] == nil || value.M["time"] == nil {
+		return nil, nil, fmt.Errorf("invalid %s attribute", ChangeAttribute)
+	}
+	change := &Change{Event: aws.StringValue(value.M["event"].S)}
+	if value.M["sequence"] != nil {
+		change.Sequence = aws.StringValue(value.M["sequence"].S)
+	}
+	if value.M["keys"] != nil {
+		change.Keys = aws.StringValueSlice(value.M["keys"].SS)
+	}
+	t, err := time.Parse(time.RFC3339Nano, aws.StringValue(value.M["time"].S))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid time of the %s attribute: %s", ChangeAttribute, err)
+	}
+	change.Time = t
+	rest := make(map[string]*dynamodb.AttributeValue, len(item)-1)
+	for name, value := range item {
+		if name != ChangeAttribute {
+			rest[name] = value
+		}
+	}
+	return change, rest, nil
+}
+
+// Key returns the key attributes of the given item, or nil if the keys of the
+// change are not known
+func (c *Change) Key(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if len(c.Keys) == 0 {
+		return nil
+	}
+	key := make(map[string]*dynamodb.AttributeValue, len(c.Keys))
+	for _, name := range c.Keys {
+		key[name] = item[name]
+	}
+	return key
+}
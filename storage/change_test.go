@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestMarkChange(t *testing.T) {
+	item := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String("Queen")}, "songs": {SS: aws.StringSlice([]string{"Under pressure"})}}
+	change := Change{Event: EventModify, Time: time.Date(2019, 1, 1, 2, 0, 0, 0, time.UTC), Sequence: "42", Keys: []string{"artist"}}
+	marked := MarkChange(item, change)
+	if len(item) != 2 || len(marked) != 3 {
+		t.Fatalf("Expecting a copy of the item holding the change, got %v", marked)
+	}
+
+	found, rest, err := SplitChange(marked)
+	if err != nil || found == nil || !reflect.DeepEqual(*found, change) || !reflect.DeepEqual(rest, item) {
+		t.Fatalf("Expecting %+v and %v, got %+v, %v, %v", change, item, found, rest, err)
+	}
+	if key := found.Key(rest); !reflect.DeepEqual(key, map[string]*dynamodb.AttributeValue{"artist": item["artist"]}) {
+		t.Errorf("Unexpected key: %v", key)
+	}
+	if found, rest, err = SplitChange(item); found != nil || !reflect.DeepEqual(rest, item) || err != nil {
+		t.Errorf("Expecting no change for a plain item, got %+v, %v, %v", found, rest, err)
+	}
+	marked[ChangeAttribute] = &dynamodb.AttributeValue{S: aws.String("INSERT")}
+	if _, _, err = SplitChange(marked); err == nil {
+		t.Error("Expecting an error for an invalid change attribute")
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// checkpointFileName is the name of the file holding the Checkpoint of a
+// backup in progress. It is removed once the backup is successful.
+const checkpointFileName = "_CHECKPOINT"
+
+// AttributeMap is a DynamoDB item or key that is marshaled to json without the
+// empty fields of its attributes
+type AttributeMap map[string]*dynamodb.AttributeValue
+
+// MarshalJSON marshals the map using MarshalDynamoAttributeMap
+func (m AttributeMap) MarshalJSON() ([]byte, error) {
+	return MarshalDynamoAttributeMap(m)
+}
+
+// SegmentPosition is the position reached by a scan segment
+type SegmentPosition struct {
+	Segment int64 `json:"segment"`
+	// StartKey is the ExclusiveStartKey to resume the scan from. It is empty
+	// when the scan has to start from the beginning of the segment.
+	StartKey AttributeMap `json:"startKey,omitempty"`
+	// Skip is the number of items after StartKey that are already written
+	Skip int64 `json:"skip,omitempty"`
+	// Done is set once all the items of the segment are written
+	Done bool `json:"done,omitempty"`
+}
+
+// Checkpoint is the state of a backup in progress, saved after each data file
+// is written so that an interrupted backup can be resumed
+type Checkpoint struct {
+	// Items is the number of items written in the data files
+	Items int64 `json:"items"`
+	// Entries are the manifest entries of the data files written so far
+	Entries     []ManifestEntry   `json:"entries"`
+	Compression string            `json:"compression,omitempty"`
+	Segments    []SegmentPosition `json:"segments"`
+	// Start is the time the interrupted backup started
+	Start *time.Time `json:"start,omitempty"`
+}
+
+// Checkpointer returns the position of each scan segment once the given
+// number of items has been written in the data files
+type Checkpointer func(items int64) []SegmentPosition
+
+// LoadCheckpoint loads the checkpoint of an interrupted backup from the given
+// folder so that the next call to Write resumes it. It returns nil if the
+// folder holds no checkpoint.
+func (h *backupBase) LoadCheckpoint(folder *FileInput) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{}
+	if found, err := h.readJSONFile(h.folderFile(folder, checkpointFileName), checkpoint); err != nil || !found {
+		return nil, err
+	}
+	if checkpoint.Compression != h.compression {
+		log.Printf("[WARNING] Resuming a backup compressed using %q, ignoring the requested compression %q\n", checkpoint.Compression, h.compression)
+		h.compression = checkpoint.Compression
+	}
+	h.checkpoint = checkpoint
+	return checkpoint, nil
+}
+
+// saveCheckpoint writes the checkpoint of the backup in progress after the
+// given number of items have been written
+func (h *backupBase) saveCheckpoint(folder *FileInput, items int64) {
+	if h.checkpointer == nil {
+		return
+	}
+	checkpoint := Checkpoint{Items: items, Entries: h.manifest.Entries, Compression: h.manifest.Compression, Segments: h.checkpointer(items), Start: h.manifest.Start}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		log.Printf("[ERROR] while marshaling the checkpoint: %s", err)
+		return
+	}
+	if err = h.store.Flush(&FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *folder.Path, checkpointFileName))}, data); err != nil {
+		log.Printf("[ERROR] while writing the checkpoint: %s", err)
+	}
+}
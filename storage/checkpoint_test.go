@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestWriteCheckpoints(t *testing.T) {
+	var wg sync.WaitGroup
+	calls := []int64{}
+	proceed := make(chan bool)
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	checkpointer := func(items int64) []SegmentPosition {
+		calls = append(calls, items)
+		// Holds the 2nd checkpoint until the 1st one is checked
+		if items == 2 {
+			<-proceed
+		}
+		return []SegmentPosition{{Segment: 0, StartKey: testItems[items-1], Skip: 0}}
+	}
+	store, folder, err := Open("mem://tests/checkpoints", &Config{DataPipe: dataPipe, Checkpointer: checkpointer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(1)
+	go store.Write(folder, 1, &wg)
+	for _, item := range testItems {
+		dataPipe <- item
+	}
+
+	// The checkpoint written after the 1st file holds 1 item
+	cpFile := &FileInput{Bucket: folder.Bucket, Path: aws.String("checkpoints/_CHECKPOINT")}
+	doc, err := store.GetFile(cpFile)
+	if err != nil {
+		t.Fatalf("Expecting a checkpoint during the backup: %s", err)
+	}
+	checkpoint := Checkpoint{}
+	if err := json.NewDecoder(*doc).Decode(&checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Items != 1 || len(checkpoint.Entries) != 1 || *checkpoint.Segments[0].StartKey["artist"].S != "Aerosmith" {
+		t.Errorf("Unexpected checkpoint: %+v", checkpoint)
+	}
+	close(proceed)
+	close(dataPipe)
+	wg.Wait()
+	if err := store.Commit(folder, nil); err != nil {
+		t.Fatalf("Unexpected error committing the backup: %s", err)
+	}
+
+	if exists, _ := store.Exists(cpFile); exists {
+		t.Errorf("The checkpoint should be removed at the end of the backup")
+	}
+	// The last checkpoint is saved once all the items are written
+	if !reflectEqualInt64(calls, []int64{1, 2, 3}) {
+		t.Errorf("Unexpected calls to the checkpointer: %v", calls)
+	}
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	var wg sync.WaitGroup
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := Open("mem://tests/resume", &Config{DataPipe: dataPipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := Checkpoint{Items: 2, Entries: []ManifestEntry{{URL: "mem://tests/resume/previous", Mandatory: true}}}
+	data, _ := json.Marshal(previous)
+	if err := store.Flush(&FileInput{Bucket: folder.Bucket, Path: aws.String("resume/_CHECKPOINT")}, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoint, err := store.LoadCheckpoint(folder); err != nil || checkpoint == nil || checkpoint.Items != 2 {
+		t.Fatalf("Unable to load the checkpoint: %v, %s", checkpoint, err)
+	}
+	wg.Add(1)
+	go store.Write(folder, 1024, &wg)
+	dataPipe <- testItems[2]
+	close(dataPipe)
+	wg.Wait()
+	if err := store.Commit(folder, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := store.GetFile(&FileInput{Bucket: folder.Bucket, Path: aws.String("resume/manifest")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := Manifest{}
+	if err := json.NewDecoder(*doc).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Entries) != 2 || manifest.Entries[0].URL != "mem://tests/resume/previous" {
+		t.Errorf("Expecting the entries of the checkpoint to be kept in the manifest: %+v", manifest)
+	}
+}
+
+// reflectEqualInt64 checks that 2 slices of int64 are equal
+func reflectEqualInt64(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// successFileName is the name of the empty file flagging a complete backup
+	successFileName = "_SUCCESS"
+	// failureFileName is the name of the file holding the Failure of a backup
+	failureFileName = "_FAILURE"
+	// stagingFolder is the folder, inside the backup folder, where the data
+	// files are written until the backup is committed when staging is enabled
+	stagingFolder = "_temporary"
+)
+
+// Failure describes why a backup failed. It is written in place of the
+// _SUCCESS flag.
+type Failure struct {
+	Error string `json:"error"`
+	Time  string `json:"time"`
+	// Items is the number of items written in the data files and Files the
+	// number of data files written before the failure
+	Items int64 `json:"items"`
+	Files int   `json:"files"`
+	// Compression and ManifestInfo are the ones the manifest would have
+	// recorded, kept for the repair of the backup
+	Compression string `json:"compression,omitempty"`
+	ManifestInfo
+}
+
+// Commit completes the backup written in the given folder by Write. If the
+// backup failed, the given failure is saved in a _FAILURE file and the
+// checkpoint is kept so that the backup can be resumed. Otherwise the staged
+// files are moved to the folder, then the manifest and the _SUCCESS flag are
+// written. If any of these steps fails, the backup is flagged as failed.
+func (h *backupBase) Commit(folder *FileInput, failure error) error {
+	if failure == nil {
+		failure = h.commitSuccess(folder)
+	}
+	if failure == nil {
+		return nil
+	}
+	data, err := json.Marshal(Failure{
+		Error:        failure.Error(),
+		Time:         time.Now().UTC().Format(time.RFC3339),
+		Items:        h.items,
+		Files:        len(h.manifest.Entries),
+		Compression:  h.manifest.Compression,
+		ManifestInfo: h.manifest.ManifestInfo,
+	})
+	if err != nil {
+		return err
+	}
+	if err = h.store.Flush(h.folderFile(folder, failureFileName), data); err != nil {
+		log.Printf("[ERROR] while writing the %s file: %s", failureFileName, err)
+	}
+	return failure
+}
+
+// SetManifestInfo records the set fields of the given info in the manifest of
+// the backup written by Write, to be called before Commit. The fields left nil
+// keep what Write recorded.
+func (h *backupBase) SetManifestInfo(info ManifestInfo) {
+	if info.Changes != nil {
+		h.manifest.Changes = info.Changes
+	}
+	if info.Incremental != nil {
+		h.manifest.Incremental = info.Incremental
+	}
+	if info.Start != nil {
+		start := info.Start.UTC()
+		h.manifest.Start = &start
+	}
+}
+
+// commitSuccess promotes the staged files and writes the manifest and the
+// _SUCCESS flag of the backup
+func (h *backupBase) commitSuccess(folder *FileInput) error {
+	if err := h.promote(); err != nil {
+		return err
+	}
+	// Wrap up the manifest of the backup files
+	end := time.Now().UTC()
+	h.manifest.Totals, h.manifest.End = h.manifest.totals(), &end
+	manifestData, err := json.Marshal(h.manifest)
+	if err != nil {
+		return fmt.Errorf("while marshaling the manifest: %s", err)
+	}
+	if err = h.store.Flush(h.folderFile(folder, "manifest"), manifestData); err != nil {
+		return fmt.Errorf("while writing the manifest file: %s", err)
+	}
+	// Signal the success of the backup
+	if err = h.store.Flush(h.folderFile(folder, successFileName), []byte{}); err != nil {
+		return fmt.Errorf("while writing the %s file: %s", successFileName, err)
+	}
+	// The backup is complete so there is nothing to resume anymore
+	for _, name := range []string{failureFileName, checkpointFileName} {
+		if err = h.store.Delete(h.folderFile(folder, name)); err != nil {
+			log.Printf("[ERROR] while removing the %s file: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// promote moves the data files written in the staging folder to the backup
+// folder and updates their manifest entries. The files already moved by a
+// previous attempt are only updated in the manifest.
+func (h *backupBase) promote() error {
+	staged := "/" + stagingFolder + "/"
+	for idx, entry := range h.manifest.Entries {
+		u, err := url.Parse(entry.URL)
+		if err != nil {
+			return err
+		}
+		from := h.store.urlToFileInput(u)
+		if from == nil || !strings.Contains(*from.Path, staged) {
+			continue
+		}
+		to := &FileInput{Bucket: from.Bucket, Path: aws.String(strings.Replace(*from.Path, staged, "/", 1))}
+		exists, err := h.store.Exists(from)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err = h.store.move(from, to); err != nil {
+				return fmt.Errorf("while moving the staged file %s: %s", entry.URL, err)
+			}
+		} else if exists, err = h.store.Exists(to); err != nil || !exists {
+			if err == nil {
+				err = fmt.Errorf("file not found")
+			}
+			return fmt.Errorf("while moving the staged file %s: %s", entry.URL, err)
+		}
+		h.manifest.Entries[idx].URL = h.store.fileURL(to)
+	}
+	return nil
+}
+
+// LoadFailure returns the Failure saved in the given backup folder or nil if
+// the backup is not flagged as failed
+func (h *backupBase) LoadFailure(folder *FileInput) (*Failure, error) {
+	failure := &Failure{}
+	if found, err := h.readJSONFile(h.folderFile(folder, failureFileName), failure); err != nil || !found {
+		return nil, err
+	}
+	return failure, nil
+}
+
+// folderFile returns the FileInput of the given file of the backup folder
+func (h *backupBase) folderFile(folder *FileInput, name string) *FileInput {
+	return &FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *folder.Path, name))}
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// failingMemBackup is a MemBackup failing to write the data files after the
+// given number of them
+type failingMemBackup struct {
+	*MemBackup
+	dataFiles int
+}
+
+func (h *failingMemBackup) Flush(input *FileInput, data []byte) error {
+	if !strings.HasPrefix(aws.StringValue(input.Path), "failing/_") {
+		if h.dataFiles == 0 {
+			return fmt.Errorf("disk full")
+		}
+		h.dataFiles--
+	}
+	return h.MemBackup.Flush(input, data)
+}
+
+// writeTestItems writes the test items in the given store using files of 1
+// byte max and returns the error of Write
+func writeTestItems(store BackupIface, dataPipe chan map[string]*dynamodb.AttributeValue, folder *FileInput) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		errs <- store.Write(folder, 1, &wg)
+	}()
+	for _, item := range testItems {
+		dataPipe <- item
+	}
+	close(dataPipe)
+	wg.Wait()
+	return <-errs
+}
+
+func TestCommitFailure(t *testing.T) {
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	store := &failingMemBackup{MemBackup: NewMemBackup(), dataFiles: 1}
+	store.store = store
+	store.configure(&Config{DataPipe: dataPipe})
+	folder := &FileInput{Bucket: aws.String("tests"), Path: aws.String("failing")}
+
+	// All the items are read even if the 2nd file can't be written
+	err := writeTestItems(store, dataPipe, folder)
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("Expecting the error of the upload, got %v", err)
+	}
+	if err = store.Commit(folder, err); err == nil {
+		t.Errorf("Expecting Commit to return the failure")
+	}
+
+	if exists, _ := store.Exists(&FileInput{Bucket: folder.Bucket, Path: aws.String("failing/_SUCCESS")}); exists {
+		t.Errorf("No _SUCCESS file expected after a failure")
+	}
+	failure, err := store.LoadFailure(folder)
+	if err != nil || failure == nil {
+		t.Fatalf("Expecting a _FAILURE file, got %v, %v", failure, err)
+	}
+	if !strings.Contains(failure.Error, "disk full") || failure.Items != 1 || failure.Files != 1 {
+		t.Errorf("Unexpected failure details: %+v", failure)
+	}
+}
+
+func TestCommitStaging(t *testing.T) {
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := Open("mem://tests/staging", &Config{DataPipe: dataPipe, Staging: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeTestItems(store, dataPipe, folder); err != nil {
+		t.Fatal(err)
+	}
+	base := store.(*MemBackup)
+	for _, entry := range base.manifest.Entries {
+		if !strings.HasPrefix(entry.URL, "mem://tests/staging/_temporary/") {
+			t.Errorf("Expecting the data files to be staged, got %s", entry.URL)
+		}
+	}
+	// Simulates a previous attempt having moved the 1st file already
+	u, _ := url.Parse(base.manifest.Entries[0].URL)
+	first := base.urlToFileInput(u)
+	if err = base.move(first, &FileInput{Bucket: first.Bucket, Path: aws.String(strings.Replace(*first.Path, "/_temporary/", "/", 1))}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Commit(folder, nil); err != nil {
+		t.Fatalf("Unexpected error committing the backup: %s", err)
+	}
+	if len(base.manifest.Entries) != len(testItems) {
+		t.Errorf("Expecting %d files, got %d", len(testItems), len(base.manifest.Entries))
+	}
+	for _, entry := range base.manifest.Entries {
+		u, _ := url.Parse(entry.URL)
+		exists, _ := store.Exists(base.urlToFileInput(u))
+		if strings.Contains(entry.URL, "_temporary") || !exists {
+			t.Errorf("Expecting the file %s to be promoted, exists: %t", entry.URL, exists)
+		}
+	}
+	if exists, _ := store.Exists(&FileInput{Bucket: folder.Bucket, Path: aws.String("staging/_SUCCESS")}); !exists {
+		t.Errorf("Expecting a _SUCCESS file")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"time"
 
 	"github.com/segmentio/ksuid"
 )
@@ -13,6 +14,19 @@ import (
 type ManifestEntry struct {
 	URL       string `json:"url"`
 	Mandatory bool   `json:"mandatory"`
+	// SHA256 is the hex encoded checksum of the file as stored, Size its
+	// size in bytes and Items its number of lines. They are absent from the
+	// backups made before they were recorded and by the AWS datapipelines.
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Items  int64  `json:"items,omitempty"`
+}
+
+// ManifestTotals are the totals of the entries of the manifest
+type ManifestTotals struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+	Items int64 `json:"items"`
 }
 
 // Manifest represents the backup manifest
@@ -20,6 +34,53 @@ type Manifest struct {
 	Name    string          `json:"name"`
 	Version int             `json:"version"`
 	Entries []ManifestEntry `json:"entries"`
+	// Compression is the codec used for the data files. It is absent for
+	// uncompressed backups such as the ones made by the AWS datapipelines.
+	Compression string `json:"compression,omitempty"`
+	// Totals is absent from the backups made before it was recorded
+	Totals *ManifestTotals `json:"totals,omitempty"`
+	ManifestInfo
+	// End is the time the writing of the backup ended. It is absent from the
+	// backups made before it was recorded.
+	End *time.Time `json:"end,omitempty"`
+}
+
+// ManifestInfo is what the manifest records about a backup besides its data
+// files, set through SetManifestInfo
+type ManifestInfo struct {
+	// Changes is only set for the backups of a change archive
+	Changes *ChangeSet `json:"changes,omitempty"`
+	// Incremental is only set for the incremental backups
+	Incremental *Incremental `json:"incremental,omitempty"`
+	// Start is the time the writing of the backup started, or for a compacted
+	// backup the start of the newest backup it merges. It is absent from the
+	// backups made before it was recorded.
+	Start *time.Time `json:"start,omitempty"`
+}
+
+// Incremental describes an incremental backup, holding the items changed
+// since the backup it is based on
+type Incremental struct {
+	// Parent is the URL of the folder of the backup the incremental backup
+	// is based on, which can be incremental too
+	Parent string `json:"parent"`
+	// Attribute is the attribute of the items holding the time they were
+	// changed, and Since the time from which the changed items are backed up
+	Attribute string    `json:"attribute,omitempty"`
+	Since     time.Time `json:"since"`
+	// KeyDiff is set when the items were compared with the ones of the
+	// parent backup: the backup then also holds the keys of the deleted items
+	KeyDiff bool `json:"keyDiff,omitempty"`
+}
+
+// totals returns the totals of the entries of the manifest
+func (m *Manifest) totals() *ManifestTotals {
+	totals := &ManifestTotals{Files: len(m.Entries)}
+	for _, entry := range m.Entries {
+		totals.Size += entry.Size
+		totals.Items += entry.Items
+	}
+	return totals
 }
 
 // FileInput is used as input for the functions that require a file definition,
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs available for the backup data files
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// codec describes how to compress and decompress the data files
+type codec struct {
+	extension string
+	magic     []byte
+	compress  func([]byte) ([]byte, error)
+	reader    func(io.Reader) (io.Reader, error)
+}
+
+var codecs = map[string]codec{
+	CompressionGzip: {
+		extension: ".gz",
+		magic:     []byte{0x1f, 0x8b},
+		compress: func(data []byte) ([]byte, error) {
+			var buff bytes.Buffer
+			w := gzip.NewWriter(&buff)
+			if _, err := w.Write(data); err != nil {
+				return nil, err
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			return buff.Bytes(), nil
+		},
+		reader: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	CompressionZstd: {
+		extension: ".zst",
+		magic:     []byte{0x28, 0xb5, 0x2f, 0xfd},
+		compress: func(data []byte) ([]byte, error) {
+			w, err := zstd.NewWriter(nil)
+			if err != nil {
+				return nil, err
+			}
+			defer w.Close()
+			return w.EncodeAll(data, nil), nil
+		},
+		reader: func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+}
+
+// CheckCompression returns an error if the given compression is unknown
+func CheckCompression(compression string) error {
+	if _, ok := codecs[compression]; !ok && compression != CompressionNone && compression != "" {
+		return fmt.Errorf("unknown compression %q, expecting %s, %s or %s", compression, CompressionGzip, CompressionZstd, CompressionNone)
+	}
+	return nil
+}
+
+// compressData compresses the given data with the given compression and
+// returns it along with the file extension to use. No compression is done for
+// an empty or "none" compression.
+func compressData(compression string, data []byte) ([]byte, string, error) {
+	c, ok := codecs[compression]
+	if !ok {
+		return data, "", nil
+	}
+	compressed, err := c.compress(data)
+	return compressed, c.extension, err
+}
+
+// decompressReader returns a reader on the decompressed content of r. If the
+// compression is not known (for example for the backups made by the AWS
+// datapipelines), it is detected from the first bytes of the data.
+func decompressReader(compression string, r io.Reader) (io.Reader, error) {
+	if c, ok := codecs[compression]; ok {
+		return c.reader(r)
+	}
+	if compression == CompressionNone {
+		return r, nil
+	}
+	br := bufio.NewReader(r)
+	for _, c := range codecs {
+		if head, err := br.Peek(len(c.magic)); err == nil && bytes.Equal(head, c.magic) {
+			return c.reader(br)
+		}
+	}
+	return br, nil
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat(`{"artist":{"s":"Aerosmith"}}`+"\n", 100))
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionNone, ""} {
+		compressed, extension, err := compressData(compression, data)
+		if err != nil {
+			t.Fatalf("Unable to compress using %q: %s", compression, err)
+		}
+		if c, ok := codecs[compression]; ok {
+			if extension != c.extension {
+				t.Errorf("Expecting the %s extension for %q. Got: %s", c.extension, compression, extension)
+			}
+			if len(compressed) >= len(data) {
+				t.Errorf("The data compressed using %q is not smaller than the original", compression)
+			}
+		} else if extension != "" || !bytes.Equal(compressed, data) {
+			t.Errorf("No compression expected for %q", compression)
+		}
+
+		// Using the known compression and detecting it from the data
+		for _, readCompression := range []string{compression, ""} {
+			reader, err := decompressReader(readCompression, bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatalf("Unable to decompress %q data: %s", compression, err)
+			}
+			decompressed, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Unable to read %q data: %s", compression, err)
+			}
+			if !bytes.Equal(decompressed, data) {
+				t.Errorf("Decompressed %q data mismatch", compression)
+			}
+		}
+	}
+}
+
+func TestCheckCompression(t *testing.T) {
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionNone, ""} {
+		if err := CheckCompression(compression); err != nil {
+			t.Errorf("Unexpected error for %q: %s", compression, err)
+		}
+	}
+	if err := CheckCompression("lzma"); err == nil {
+		t.Errorf("Expecting an error for an unknown compression")
+	}
+}
@@ -18,6 +18,8 @@ type CustomAttributeValue struct {
 	SS   []*string                        `json:"ss,omitempty"`
 	L    []*CustomAttributeValue          `json:"l,omitempty"`
 	M    map[string]*CustomAttributeValue `json:"m,omitempty"`
+	// NULLValue is the name given to NULL by the AWS datapipelines exports
+	NULLValue *bool `json:"nULLValue,omitempty"`
 }
 
 // Unmarshal translates the current CustomAttributeValue to a *dynamodb.AttributeValue
@@ -28,9 +30,12 @@ func (attr *CustomAttributeValue) Unmarshal(out *dynamodb.AttributeValue) {
 	out.N = attr.N
 	out.NS = attr.NS
 	out.NULL = attr.NULL
+	if attr.NULLValue != nil {
+		out.NULL = attr.NULLValue
+	}
 	out.S = attr.S
 	out.SS = attr.SS
-	if len(attr.L) > 0 {
+	if attr.L != nil {
 		out.L = []*dynamodb.AttributeValue{}
 	}
 	for _, child := range attr.L {
@@ -39,7 +44,7 @@ func (attr *CustomAttributeValue) Unmarshal(out *dynamodb.AttributeValue) {
 		out.L = append(out.L, &translated)
 	}
 
-	if len(attr.M) > 0 {
+	if attr.M != nil {
 		out.M = make(map[string]*dynamodb.AttributeValue)
 	}
 	for k, v := range attr.M {
@@ -95,3 +100,21 @@ func MarshalDynamoAttributeMap(attrs map[string]*dynamodb.AttributeValue) ([]byt
 	}
 	return json.Marshal(resultMap)
 }
+
+// UnmarshalDynamoAttributeMap decodes a line of a data file, written by
+// MarshalDynamoAttributeMap or by the AWS datapipelines, into an item
+func UnmarshalDynamoAttributeMap(data []byte) (map[string]*dynamodb.AttributeValue, error) {
+	attrs := map[string]*CustomAttributeValue{}
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return nil, err
+	}
+	item := make(map[string]*dynamodb.AttributeValue, len(attrs))
+	for k, v := range attrs {
+		translated := dynamodb.AttributeValue{}
+		if v != nil {
+			v.Unmarshal(&translated)
+		}
+		item[k] = &translated
+	}
+	return item, nil
+}
@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+)
+
+// DeleteBackup removes all the files of the given backup folder. The _SUCCESS
+// flag and the manifest are removed first so that a partially deleted backup
+// is never seen as complete. Deleting a file that does not exist anymore is not
+// an error for any of the backends.
+func (h *backupBase) DeleteBackup(folder *FileInput) error {
+	files, err := h.store.ListFiles(folder)
+	if err != nil {
+		return err
+	}
+	first := []*FileInput{h.folderFile(folder, successFileName), h.folderFile(folder, "manifest")}
+	for _, file := range append(first, files...) {
+		if err = h.store.Delete(file); err != nil {
+			return fmt.Errorf("while removing %s: %s", h.store.fileURL(file), err)
+		}
+	}
+	log.Printf("Removed %d files from %s\n", len(files), h.store.fileURL(folder))
+	return h.store.removeFolder(folder)
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestDeleteBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dynamodbdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, location := range []string{"mem://tests/delete/myTable", "file://" + filepath.ToSlash(dir) + "/myTable"} {
+		store, root, err := Open(location, &Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{"2019-01-01-02-00-00/_SUCCESS", "2019-01-01-02-00-00/manifest", "2019-01-01-02-00-00/_temporary/a", "2019-01-02-02-00-00/_SUCCESS"} {
+			if err := store.Flush(&FileInput{Bucket: root.Bucket, Path: aws.String(*root.Path + "/" + path)}, []byte{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		folder := &FileInput{Bucket: root.Bucket, Path: aws.String(*root.Path + "/2019-01-01-02-00-00")}
+		files, err := store.ListFiles(folder)
+		if err != nil || len(files) != 3 {
+			t.Errorf("%s: expecting 3 files, got %d, %v", location, len(files), err)
+		}
+
+		if err = store.DeleteBackup(folder); err != nil {
+			t.Fatalf("%s: %s", location, err)
+		}
+		folders, err := store.ListFolders(root)
+		if err != nil || !reflect.DeepEqual(folders, []string{"2019-01-02-02-00-00"}) {
+			t.Errorf("%s: unexpected folders left: %v, %v", location, folders, err)
+		}
+	}
+}
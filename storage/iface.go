@@ -18,6 +18,24 @@ type BackupIface interface {
 	Flush(input *FileInput, data []byte) error
 	Scan(*io.ReadCloser) error
 	WriteToDB(string, int64, time.Duration, *sync.WaitGroup) error
-	DumpBuffer(*FileInput, *bytes.Buffer)
-	Write(*FileInput, int, *sync.WaitGroup)
+	DumpBuffer(*FileInput, *bytes.Buffer) error
+	Write(*FileInput, int, *sync.WaitGroup) error
+	Commit(*FileInput, error) error
+	SetManifestInfo(ManifestInfo)
+	LoadFailure(*FileInput) (*Failure, error)
+	Verify(*FileInput) (*VerifyReport, error)
+	RebuildManifest(*FileInput, bool) (*VerifyReport, error)
+	Repair(*FileInput, bool) (*VerifyReport, error)
+	ListFolders(*FileInput) ([]string, error)
+	ListFiles(*FileInput) ([]*FileInput, error)
+	DeleteBackup(*FileInput) error
+	FindBackup(*FileInput, time.Time) (*DatedBackup, error)
+	Describe(*FileInput) (*BackupInfo, error)
+	ReadManifest(*FileInput) (*Manifest, error)
+	ListBackups(*FileInput) ([]BackupInfo, error)
+	LoadCheckpoint(*FileInput) (*Checkpoint, error)
+	Delete(*FileInput) error
+	ResumeRestore(*RestoreState)
+	Acknowledge(first, count int64)
+	RestoreState() RestoreState
 }
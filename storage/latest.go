@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// DateFolderFormat is the layout of the names of the folders created by the
+// backups made with a date folder, in UTC
+const DateFolderFormat = "2006-01-02-15-04-05"
+
+// DatedBackup is a backup found in a date folder
+type DatedBackup struct {
+	Folder *FileInput
+	Time   time.Time
+}
+
+// FindBackup returns the newest complete backup of the date folders of the
+// given folder made at or before asOf, or the newest one if asOf is zero
+func (h *backupBase) FindBackup(folder *FileInput, asOf time.Time) (*DatedBackup, error) {
+	names, err := h.store.ListFolders(folder)
+	if err != nil {
+		return nil, err
+	}
+	// Newest first, the format being sortable
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for _, name := range names {
+		t, err := time.Parse(DateFolderFormat, name)
+		if err != nil || (!asOf.IsZero() && t.After(asOf)) {
+			continue
+		}
+		backup := &DatedBackup{Folder: &FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *folder.Path, name))}, Time: t}
+		exists, err := h.store.Exists(h.folderFile(backup.Folder, successFileName))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return backup, nil
+		}
+		log.Printf("Skipping the incomplete backup %s\n", name)
+	}
+	if asOf.IsZero() {
+		return nil, fmt.Errorf("no complete backup found in %s", h.store.fileURL(folder))
+	}
+	return nil, fmt.Errorf("no complete backup made before %s found in %s", asOf.Format(time.RFC3339), h.store.fileURL(folder))
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestFindBackup(t *testing.T) {
+	store, folder, err := Open("mem://tests/latest/myTable", &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{
+		"latest/myTable/2019-01-01-02-00-00/_SUCCESS": "",
+		"latest/myTable/2019-01-02-02-00-00/_SUCCESS": "",
+		"latest/myTable/2019-01-03-02-00-00/manifest": "{}",
+		"latest/myTable/not-a-date/_SUCCESS":          "",
+	} {
+		if err := store.Flush(&FileInput{Bucket: folder.Bucket, Path: aws.String(path)}, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	folders, err := store.ListFolders(folder)
+	if err != nil || !reflect.DeepEqual(folders, []string{"2019-01-01-02-00-00", "2019-01-02-02-00-00", "2019-01-03-02-00-00", "not-a-date"}) {
+		t.Errorf("Unexpected folders: %v, %v", folders, err)
+	}
+
+	testCases := []struct {
+		asOf     time.Time
+		expected string
+	}{
+		// The newest backup is incomplete
+		{asOf: time.Time{}, expected: "latest/myTable/2019-01-02-02-00-00"},
+		{asOf: time.Date(2019, 1, 2, 2, 0, 0, 0, time.UTC), expected: "latest/myTable/2019-01-02-02-00-00"},
+		{asOf: time.Date(2019, 1, 2, 1, 59, 59, 0, time.UTC), expected: "latest/myTable/2019-01-01-02-00-00"},
+		{asOf: time.Date(2018, 12, 31, 0, 0, 0, 0, time.UTC), expected: ""},
+	}
+	for _, tc := range testCases {
+		backup, err := store.FindBackup(folder, tc.asOf)
+		switch {
+		case tc.expected == "" && err == nil:
+			t.Errorf("Expecting no backup as of %s, got %s", tc.asOf, *backup.Folder.Path)
+		case tc.expected != "" && err != nil:
+			t.Errorf("Unexpected error as of %s: %s", tc.asOf, err)
+		case tc.expected != "" && *backup.Folder.Path != tc.expected:
+			t.Errorf("Expecting %s as of %s, got %s", tc.expected, tc.asOf, *backup.Folder.Path)
+		}
+	}
+}
+
+func TestLocalListFolders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dynamodbdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewLocalBackup()
+	for _, path := range []string{"b/2019-01-02-02-00-00/_SUCCESS", "b/2019-01-01-02-00-00/manifest", "b/file"} {
+		if err := store.Flush(&FileInput{Bucket: aws.String(dir), Path: aws.String(path)}, []byte{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	folders, err := store.ListFolders(&FileInput{Bucket: aws.String(dir), Path: aws.String("b")})
+	if err != nil || !reflect.DeepEqual(folders, []string{"2019-01-01-02-00-00", "2019-01-02-02-00-00"}) {
+		t.Errorf("Unexpected folders: %v, %v", folders, err)
+	}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Status of a backup
+const (
+	StatusComplete   = "complete"
+	StatusFailed     = "failed"
+	StatusInProgress = "in-progress"
+	StatusIncomplete = "incomplete"
+)
+
+// BackupInfo describes a backup folder
+type BackupInfo struct {
+	Folder *FileInput `json:"-"`
+	URL    string     `json:"url"`
+	// Time is the time the backup started, only known for the backups made
+	// in a date folder
+	Time *time.Time `json:"time,omitempty"`
+	// Status is StatusComplete if the backup has a _SUCCESS flag,
+	// StatusFailed if it has a _FAILURE file, StatusInProgress if it has a
+	// checkpoint and StatusIncomplete otherwise
+	Status string `json:"status"`
+	Files  int    `json:"files"`
+	// Size and Items are only known for the backups recording them in their
+	// manifest
+	Size  *int64 `json:"size,omitempty"`
+	Items *int64 `json:"items,omitempty"`
+	// Parent is the URL of the backup an incremental backup is based on
+	Parent string `json:"parent,omitempty"`
+}
+
+// Describe returns the BackupInfo of the given backup folder, using its
+// manifest or, for an unfinished backup, its checkpoint
+func (h *backupBase) Describe(folder *FileInput) (*BackupInfo, error) {
+	info := &BackupInfo{Folder: folder, URL: h.store.fileURL(folder), Status: StatusIncomplete}
+	complete, err := h.store.Exists(h.folderFile(folder, successFileName))
+	if err != nil {
+		return nil, err
+	}
+	failed, err := h.store.Exists(h.folderFile(folder, failureFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := h.ReadManifest(folder)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &Checkpoint{}
+	inProgress := false
+	if manifest == nil {
+		if inProgress, err = h.readJSONFile(h.folderFile(folder, checkpointFileName), checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case complete:
+		info.Status = StatusComplete
+	case failed:
+		info.Status = StatusFailed
+	case inProgress:
+		info.Status = StatusInProgress
+	}
+	switch {
+	case manifest != nil:
+		totals := manifest.Totals
+		if totals == nil {
+			totals = manifest.totals()
+		}
+		info.Files = len(manifest.Entries)
+		if manifest.Incremental != nil {
+			info.Parent = manifest.Incremental.Parent
+		}
+		// The backups made before the sizes were recorded have none
+		if totals.Size > 0 || len(manifest.Entries) == 0 {
+			info.Size = aws.Int64(totals.Size)
+			info.Items = aws.Int64(totals.Items)
+		}
+	case inProgress:
+		info.Files = len(checkpoint.Entries)
+		info.Items = aws.Int64(checkpoint.Items)
+	}
+	return info, nil
+}
+
+// ReadManifest returns the manifest of the given backup folder or nil if it
+// has none
+func (h *backupBase) ReadManifest(folder *FileInput) (*Manifest, error) {
+	manifest := &Manifest{}
+	if found, err := h.readJSONFile(h.folderFile(folder, "manifest"), manifest); err != nil || !found {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ListBackups returns the BackupInfo of the backups found in the date folders
+// of the given folder, oldest first. The folder itself is listed first if it
+// holds a backup.
+func (h *backupBase) ListBackups(folder *FileInput) ([]BackupInfo, error) {
+	backups := []BackupInfo{}
+	info, err := h.Describe(folder)
+	if err != nil {
+		return nil, err
+	}
+	if info.Status != StatusIncomplete || info.Files > 0 {
+		backups = append(backups, *info)
+	}
+
+	names, err := h.store.ListFolders(folder)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		t, err := time.Parse(DateFolderFormat, name)
+		if err != nil {
+			continue
+		}
+		info, err := h.Describe(&FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *folder.Path, name))})
+		if err != nil {
+			return nil, err
+		}
+		info.Time = &t
+		backups = append(backups, *info)
+	}
+	return backups, nil
+}
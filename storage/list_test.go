@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestListBackups(t *testing.T) {
+	store, _ := backupTestItems(t, "mem://tests/list/myTable/2019-01-01-02-00-00")
+	folder := &FileInput{Bucket: aws.String("tests"), Path: aws.String("list/myTable")}
+	for path, content := range map[string]string{
+		"list/myTable/2019-01-02-02-00-00/_FAILURE":    `{"error":"disk full"}`,
+		"list/myTable/2019-01-03-02-00-00/_CHECKPOINT": `{"items":12,"entries":[{"url":"mem://tests/list/myTable/2019-01-03-02-00-00/a"}]}`,
+		"list/myTable/2019-01-04-02-00-00/schema.json": `{}`,
+		"list/myTable/other/_SUCCESS":                  "",
+	} {
+		if err := store.Flush(&FileInput{Bucket: folder.Bucket, Path: aws.String(path)}, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := store.ListBackups(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 4 {
+		t.Fatalf("Expecting the 4 backups of the date folders, got %+v", backups)
+	}
+	expected := []struct {
+		status string
+		files  int
+		items  *int64
+	}{
+		{StatusComplete, len(testItems), aws.Int64(int64(len(testItems)))},
+		{StatusFailed, 0, nil},
+		{StatusInProgress, 1, aws.Int64(12)},
+		{StatusIncomplete, 0, nil},
+	}
+	for idx, backup := range backups {
+		if backup.Status != expected[idx].status || backup.Files != expected[idx].files || aws.Int64Value(backup.Items) != aws.Int64Value(expected[idx].items) || (backup.Items == nil) != (expected[idx].items == nil) {
+			t.Errorf("Unexpected backup %d: %+v", idx, backup)
+		}
+		if backup.Time == nil || backup.Time.Day() != idx+1 {
+			t.Errorf("Unexpected time for backup %d: %v", idx, backup.Time)
+		}
+	}
+	if backups[0].Size == nil || *backups[0].Size == 0 {
+		t.Errorf("Expecting the size of the complete backup")
+	}
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// LocalBackup is the structure that implements the backup storage on the local
+// filesystem. The Bucket of a FileInput is used as the base directory and its
+// Path as the path of the file inside this directory.
+type LocalBackup struct {
+	backupBase
+}
+
+// NewLocalBackup returns a pointer to a LocalBackup struct
+func NewLocalBackup() *LocalBackup {
+	b := &LocalBackup{}
+	b.store = b
+	return b
+}
+
+func init() {
+	Register("file", openLocalBackup)
+}
+
+// openLocalBackup is the Constructor of the file:// URLs. Relative paths can
+// be given as file:relative/path or file://relative/path.
+func openLocalBackup(u *url.URL, cfg *Config) (BackupIface, *FileInput, error) {
+	path := u.Opaque
+	if path == "" {
+		path = u.Host + u.Path
+	}
+	if path == "" {
+		return nil, nil, fmt.Errorf("no path provided in %s", u)
+	}
+	b := NewLocalBackup()
+	b.configure(cfg)
+	return b, &FileInput{Bucket: aws.String(""), Path: aws.String(filepath.FromSlash(path))}, nil
+}
+
+// filePath returns the path on disk of the given file
+func (h *LocalBackup) filePath(input *FileInput) string {
+	return filepath.Join(aws.StringValue(input.Bucket), filepath.FromSlash(aws.StringValue(input.Path)))
+}
+
+// GetFile opens the given file for reading
+func (h *LocalBackup) GetFile(input *FileInput) (*io.ReadCloser, error) {
+	f, err := os.Open(h.filePath(input))
+	if err != nil {
+		return nil, err
+	}
+	var r io.ReadCloser = f
+	return &r, nil
+}
+
+// Exists checks that a given path exists on disk as a file
+func (h *LocalBackup) Exists(input *FileInput) (bool, error) {
+	info, err := os.Stat(h.filePath(input))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+// Flush writes the content of a bytes array to the given file, creating the
+// parent directories if needed
+func (h *LocalBackup) Flush(input *FileInput, data []byte) error {
+	path := h.filePath(input)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	log.Printf("Writing file: %s\n", path)
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Delete removes the given file from the disk. Removing a file that does not
+// exist is not an error.
+func (h *LocalBackup) Delete(input *FileInput) error {
+	if err := os.Remove(h.filePath(input)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ListFolders returns the sorted names of the directories found in the given
+// folder
+func (h *LocalBackup) ListFolders(folder *FileInput) ([]string, error) {
+	infos, err := ioutil.ReadDir(h.filePath(folder))
+	if err != nil {
+		return nil, err
+	}
+	folders := []string{}
+	for _, info := range infos {
+		if info.IsDir() {
+			folders = append(folders, info.Name())
+		}
+	}
+	return folders, nil
+}
+
+// ListFiles returns the files found in the given folder and its
+// sub-directories, sorted by path. Their paths are relative to the Bucket of
+// the folder.
+func (h *LocalBackup) ListFiles(folder *FileInput) ([]*FileInput, error) {
+	files := []*FileInput{}
+	root := h.filePath(folder)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, &FileInput{Bucket: folder.Bucket, Path: aws.String(filepath.Join(aws.StringValue(folder.Path), rel))})
+		return nil
+	})
+	return files, err
+}
+
+// removeFolder removes the given directory and the empty directories it holds
+func (h *LocalBackup) removeFolder(folder *FileInput) error {
+	root := h.filePath(folder)
+	dirs := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return err
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// Deepest first so that the parents are empty when removed
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err = os.Remove(dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move renames the given file, creating the parent directories of the
+// destination if needed
+func (h *LocalBackup) move(from, to *FileInput) error {
+	path := h.filePath(to)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.Rename(h.filePath(from), path)
+}
+
+// fileURL returns the file:// URL of the given file
+func (h *LocalBackup) fileURL(input *FileInput) string {
+	path := h.filePath(input)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// urlToFileInput returns the FileInput matching the given file:// URL
+func (h *LocalBackup) urlToFileInput(u *url.URL) *FileInput {
+	if u.Scheme != "file" {
+		return nil
+	}
+	return &FileInput{Bucket: aws.String(""), Path: aws.String(filepath.FromSlash(u.Path))}
+}
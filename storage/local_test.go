@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// testItems is the data set used to check the storage backends
+var testItems = []map[string]*dynamodb.AttributeValue{
+	{"artist": {S: aws.String("Aerosmith")}, "year": {N: aws.String("1973")}},
+	{"artist": {S: aws.String("Queen")}, "songs": {SS: []*string{aws.String("Under pressure")}}},
+	{"artist": {S: aws.String("Metallica")}, "active": {BOOL: aws.Bool(true)}},
+}
+
+func TestLocalBackupRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dynamodbdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Backup the items in files of 100 bytes max
+	var wg sync.WaitGroup
+	store := NewLocalBackup()
+	store.DataPipe = make(chan map[string]*dynamodb.AttributeValue)
+	folder := &FileInput{Bucket: aws.String(dir), Path: aws.String("backups/myTable")}
+	wg.Add(1)
+	go store.Write(folder, 100, &wg)
+	for _, item := range testItems {
+		store.DataPipe <- item
+	}
+	close(store.DataPipe)
+	wg.Wait()
+	if err := store.Commit(folder, nil); err != nil {
+		t.Fatalf("Unable to commit the backup: %s", err)
+	}
+
+	if exists, err := store.Exists(&FileInput{Bucket: aws.String(dir), Path: aws.String("backups/myTable/_SUCCESS")}); err != nil || !exists {
+		t.Fatalf("Expected a _SUCCESS file, got exists=%t, err=%v", exists, err)
+	}
+
+	// Restore them
+	restore := NewLocalBackup()
+	restore.DataPipe = make(chan map[string]*dynamodb.AttributeValue)
+	if err := restore.LoadManifest(&FileInput{Bucket: aws.String(dir), Path: aws.String("backups/myTable/manifest")}); err != nil {
+		t.Fatalf("Unable to load the manifest: %s", err)
+	}
+	if len(restore.manifest.Entries) < 2 {
+		t.Errorf("Expected the data to be split in several files, got %d", len(restore.manifest.Entries))
+	}
+	received := []map[string]*dynamodb.AttributeValue{}
+	done := make(chan bool)
+	go func() {
+		for elem := range restore.DataPipe {
+			received = append(received, elem)
+		}
+		done <- true
+	}()
+	if err := restore.WriteToDB("myTable", 25, 0, &wg); err != nil {
+		t.Fatalf("Unable to read the backup: %s", err)
+	}
+	<-done
+
+	if !reflect.DeepEqual(received, testItems) {
+		t.Errorf("Restored items mismatch. Expecting: %v\nGot: %v", testItems, received)
+	}
+}
+
+func TestLocalBackupDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dynamodbdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var wg sync.WaitGroup
+	store := NewLocalBackup()
+	store.DataPipe = make(chan map[string]*dynamodb.AttributeValue)
+	folder := &FileInput{Bucket: aws.String(dir), Path: aws.String("backups/myTable")}
+	wg.Add(1)
+	go store.Write(folder, 100, &wg)
+	for _, item := range testItems {
+		store.DataPipe <- item
+	}
+	close(store.DataPipe)
+	wg.Wait()
+	if err := store.Commit(folder, nil); err != nil {
+		t.Fatalf("Unable to commit the backup: %s", err)
+	}
+
+	files, err := store.ListFiles(folder)
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Expecting the files of the backup, got %v, %v", files, err)
+	}
+	for _, file := range files {
+		if exists, err := store.Exists(file); err != nil || !exists {
+			t.Errorf("Expecting %s to be relative to %s, got exists=%t, err=%v", *file.Path, dir, exists, err)
+		}
+	}
+	if err := store.DeleteBackup(folder); err != nil {
+		t.Fatalf("Unable to delete the backup: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "backups", "myTable")); !os.IsNotExist(err) {
+		t.Errorf("Expecting the backup folder to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// memFiles holds the content of the files of the in-memory storage. It is
+// shared by all the MemBackup instances of the process.
+var memFiles = struct {
+	sync.RWMutex
+	data map[string][]byte
+}{data: map[string][]byte{}}
+
+// MemBackup is the structure that implements an in-memory backup storage. The
+// data only lives as long as the process does, so it is mostly useful for
+// testing and dry-runs. The Bucket of a FileInput is used as a namespace and
+// its Path as the name of the file inside it.
+type MemBackup struct {
+	backupBase
+}
+
+// NewMemBackup returns a pointer to a MemBackup struct
+func NewMemBackup() *MemBackup {
+	b := &MemBackup{}
+	b.store = b
+	return b
+}
+
+func init() {
+	Register("mem", openMemBackup)
+}
+
+// openMemBackup is the Constructor of the mem:// URLs. The host of the URL is
+// the namespace and its path the folder inside it.
+func openMemBackup(u *url.URL, cfg *Config) (BackupIface, *FileInput, error) {
+	b := NewMemBackup()
+	b.configure(cfg)
+	return b, &FileInput{Bucket: aws.String(u.Host), Path: aws.String(strings.TrimPrefix(u.Path, "/"))}, nil
+}
+
+// memKey returns the key of the given file in memFiles
+func memKey(input *FileInput) string {
+	return aws.StringValue(input.Bucket) + "/" + strings.TrimPrefix(aws.StringValue(input.Path), "/")
+}
+
+// GetFile returns a reader on the content of the given file
+func (h *MemBackup) GetFile(input *FileInput) (*io.ReadCloser, error) {
+	memFiles.RLock()
+	data, ok := memFiles.data[memKey(input)]
+	memFiles.RUnlock()
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: h.fileURL(input), Err: os.ErrNotExist}
+	}
+	r := ioutil.NopCloser(bytes.NewReader(data))
+	return &r, nil
+}
+
+// Exists checks that a given file exists in memory
+func (h *MemBackup) Exists(input *FileInput) (bool, error) {
+	memFiles.RLock()
+	defer memFiles.RUnlock()
+	_, ok := memFiles.data[memKey(input)]
+	return ok, nil
+}
+
+// Flush stores a copy of the given bytes array as the content of the given file
+func (h *MemBackup) Flush(input *FileInput, data []byte) error {
+	memFiles.Lock()
+	defer memFiles.Unlock()
+	memFiles.data[memKey(input)] = append([]byte{}, data...)
+	return nil
+}
+
+// Delete removes the given file from memory
+func (h *MemBackup) Delete(input *FileInput) error {
+	memFiles.Lock()
+	defer memFiles.Unlock()
+	delete(memFiles.data, memKey(input))
+	return nil
+}
+
+// ListFolders returns the sorted names of the folders holding files under the
+// given folder
+func (h *MemBackup) ListFolders(folder *FileInput) ([]string, error) {
+	prefix := strings.TrimSuffix(memKey(folder), "/") + "/"
+	memFiles.RLock()
+	defer memFiles.RUnlock()
+	found := map[string]bool{}
+	for key := range memFiles.data {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if parts := strings.SplitN(strings.TrimPrefix(key, prefix), "/", 2); len(parts) == 2 {
+			found[parts[0]] = true
+		}
+	}
+	folders := []string{}
+	for name := range found {
+		folders = append(folders, name)
+	}
+	sort.Strings(folders)
+	return folders, nil
+}
+
+// ListFiles returns the files found under the given folder and its
+// sub-folders, sorted by name
+func (h *MemBackup) ListFiles(folder *FileInput) ([]*FileInput, error) {
+	prefix := strings.TrimSuffix(memKey(folder), "/") + "/"
+	memFiles.RLock()
+	defer memFiles.RUnlock()
+	paths := []string{}
+	for key := range memFiles.data {
+		if strings.HasPrefix(key, prefix) {
+			paths = append(paths, strings.TrimPrefix(key, aws.StringValue(folder.Bucket)+"/"))
+		}
+	}
+	sort.Strings(paths)
+	files := []*FileInput{}
+	for _, path := range paths {
+		files = append(files, &FileInput{Bucket: folder.Bucket, Path: aws.String(path)})
+	}
+	return files, nil
+}
+
+// removeFolder does nothing as the folders only exist through the names of
+// their files in memory
+func (h *MemBackup) removeFolder(folder *FileInput) error {
+	return nil
+}
+
+// move renames the given file in memory
+func (h *MemBackup) move(from, to *FileInput) error {
+	memFiles.Lock()
+	defer memFiles.Unlock()
+	data, ok := memFiles.data[memKey(from)]
+	if !ok {
+		return &os.PathError{Op: "rename", Path: h.fileURL(from), Err: os.ErrNotExist}
+	}
+	delete(memFiles.data, memKey(from))
+	memFiles.data[memKey(to)] = data
+	return nil
+}
+
+// fileURL returns the mem:// URL of the given file
+func (h *MemBackup) fileURL(input *FileInput) string {
+	return fmt.Sprintf("mem://%s", memKey(input))
+}
+
+// urlToFileInput returns the FileInput matching the given mem:// URL
+func (h *MemBackup) urlToFileInput(u *url.URL) *FileInput {
+	if u.Scheme != "mem" {
+		return nil
+	}
+	return &FileInput{Bucket: aws.String(u.Host), Path: aws.String(u.Path)}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Config holds the settings passed to the storage backends constructors
+type Config struct {
+	// Session is used by the backends relying on AWS services
+	Session client.ConfigProvider
+	// S3Config overrides the settings of the session for the s3 client, for
+	// example its region or its endpoint
+	S3Config *aws.Config
+	// DataPipe is the channel the backend reads from during a backup and
+	// writes to during a restore
+	DataPipe chan map[string]*dynamodb.AttributeValue
+	// Compression is the codec used for the data files written during a
+	// backup: CompressionGzip, CompressionZstd or CompressionNone
+	Compression string
+	// Checkpointer gives the scan position to save in the checkpoints of a
+	// backup. No checkpoint is saved if nil.
+	Checkpointer Checkpointer
+	// Staging makes the backups write their data files in a staging folder
+	// and move them to the backup folder once they are all written
+	Staging bool
+}
+
+// Constructor creates a storage backend from the given URL and returns it
+// along with the FileInput of the folder the URL points to
+type Constructor func(u *url.URL, cfg *Config) (BackupIface, *FileInput, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Constructor{}
+)
+
+// Register makes a storage backend available for the given URL scheme. It is
+// meant to be called from the init function of the backends.
+func Register(scheme string, constructor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[scheme]; dup {
+		panic("storage: Register called twice for scheme " + scheme)
+	}
+	registry[scheme] = constructor
+}
+
+// Schemes returns the sorted list of the registered URL schemes
+func Schemes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	schemes := []string{}
+	for scheme := range registry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open parses the given URL (for example s3://bucket/prefix or
+// file:///var/backups/x) and returns the storage backend registered for its
+// scheme along with the FileInput of the folder it points to
+func Open(rawURL string, cfg *Config) (BackupIface, *FileInput, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid storage URL %q: %s", rawURL, err)
+	}
+	if err := CheckCompression(cfg.Compression); err != nil {
+		return nil, nil, err
+	}
+	registryMu.RLock()
+	constructor, ok := registry[u.Scheme]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported storage scheme %q in %q, available schemes: %v", u.Scheme, rawURL, Schemes())
+	}
+	return constructor(u, cfg)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestOpen(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+	openTest := []struct {
+		url            string
+		expectedType   BackupIface
+		expectedBucket string
+		expectedPath   string
+	}{
+		{url: "s3://my-bucket/backups/myTable", expectedType: &S3Backup{}, expectedBucket: "my-bucket", expectedPath: "backups/myTable"},
+		{url: "file:///var/backups/myTable", expectedType: &LocalBackup{}, expectedBucket: "", expectedPath: "/var/backups/myTable"},
+		{url: "file:backups/myTable", expectedType: &LocalBackup{}, expectedBucket: "", expectedPath: "backups/myTable"},
+		{url: "file://backups/myTable", expectedType: &LocalBackup{}, expectedBucket: "", expectedPath: "backups/myTable"},
+		{url: "mem://tests/myTable", expectedType: &MemBackup{}, expectedBucket: "tests", expectedPath: "myTable"},
+	}
+	for _, item := range openTest {
+		store, folder, err := Open(item.url, &Config{Session: sess})
+		if err != nil {
+			t.Fatalf("Unexpected error opening %s: %s", item.url, err)
+		}
+		if reflect.TypeOf(store) != reflect.TypeOf(item.expectedType) {
+			t.Errorf("%s should return a %T. Got: %T", item.url, item.expectedType, store)
+		}
+		if *folder.Bucket != item.expectedBucket || *folder.Path != item.expectedPath {
+			t.Errorf("%s should point to bucket %q and path %q. Got: %q and %q", item.url, item.expectedBucket, item.expectedPath, *folder.Bucket, *folder.Path)
+		}
+	}
+
+	for _, wrongURL := range []string{"ftp://server/backups", "s3:///backups", "file://"} {
+		if _, _, err := Open(wrongURL, &Config{Session: sess}); err == nil {
+			t.Errorf("Expecting an error when opening %s", wrongURL)
+		}
+	}
+}
+
+func TestMemBackupRoundTrip(t *testing.T) {
+	var wg sync.WaitGroup
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := Open("mem://tests/roundtrip", &Config{DataPipe: dataPipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(1)
+	go store.Write(folder, 10*1024*1024, &wg)
+	for _, item := range testItems {
+		dataPipe <- item
+	}
+	close(dataPipe)
+	wg.Wait()
+	if err := store.Commit(folder, nil); err != nil {
+		t.Fatalf("Unable to commit the backup: %s", err)
+	}
+
+	restorePipe := make(chan map[string]*dynamodb.AttributeValue)
+	restore, folder, err := Open("mem://tests/roundtrip", &Config{DataPipe: restorePipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restore.LoadManifest(&FileInput{Bucket: folder.Bucket, Path: aws.String(*folder.Path + "/manifest")}); err != nil {
+		t.Fatalf("Unable to load the manifest: %s", err)
+	}
+	received := []map[string]*dynamodb.AttributeValue{}
+	done := make(chan bool)
+	go func() {
+		for elem := range restorePipe {
+			received = append(received, elem)
+		}
+		done <- true
+	}()
+	if err := restore.WriteToDB("myTable", 25, 0, &wg); err != nil {
+		t.Fatalf("Unable to read the backup: %s", err)
+	}
+	<-done
+
+	if !reflect.DeepEqual(received, testItems) {
+		t.Errorf("Restored items mismatch. Expecting: %v\nGot: %v", testItems, received)
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+)
+
+// isDataFile returns whether the file found at the given path, relative to the
+// backup folder, is a data file. The data files are the ones of the folder and
+// of its staging folder that are neither the manifest, nor a json file such as
+// the schema, nor a file starting with "_" such as the flags and checkpoints.
+func isDataFile(relative string) bool {
+	dir, name := path.Split(relative)
+	if dir != "" && dir != stagingFolder+"/" {
+		return false
+	}
+	return name != "manifest" && !strings.HasSuffix(name, ".json") && !strings.HasPrefix(name, "_") && !strings.HasPrefix(name, ".")
+}
+
+// dataFiles returns the data files found in the given backup folder
+func (h *backupBase) dataFiles(folder *FileInput) ([]*FileInput, error) {
+	files, err := h.store.ListFiles(folder)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(h.store.fileURL(folder), "/") + "/"
+	found := []*FileInput{}
+	for _, file := range files {
+		if isDataFile(strings.TrimPrefix(h.store.fileURL(file), prefix)) {
+			found = append(found, file)
+		}
+	}
+	return found, nil
+}
+
+// recoveredManifest returns a manifest without entries holding the
+// compression, start, changes and incremental parent of the backup of the given
+// folder, as recorded by its manifest, its _FAILURE file or its checkpoint,
+// whichever is found first. The files that can't be read are skipped.
+func (h *backupBase) recoveredManifest(folder *FileInput) Manifest {
+	manifest := Manifest{Version: 3, Name: "DynamoDB-export"}
+	previous, err := h.ReadManifest(folder)
+	if err != nil {
+		log.Printf("[WARNING] Ignoring the unreadable manifest of %s: %s\n", h.store.fileURL(folder), err)
+	}
+	if previous != nil {
+		manifest.Compression, manifest.ManifestInfo = previous.Compression, previous.ManifestInfo
+		return manifest
+	}
+	failure, err := h.LoadFailure(folder)
+	if err != nil {
+		log.Printf("[WARNING] Ignoring the unreadable %s file of %s: %s\n", failureFileName, h.store.fileURL(folder), err)
+	}
+	if failure != nil {
+		manifest.Compression, manifest.ManifestInfo = failure.Compression, failure.ManifestInfo
+		return manifest
+	}
+	checkpoint := &Checkpoint{}
+	found, err := h.readJSONFile(h.folderFile(folder, checkpointFileName), checkpoint)
+	if err != nil {
+		log.Printf("[WARNING] Ignoring the unreadable checkpoint of %s: %s\n", h.store.fileURL(folder), err)
+	}
+	if found && err == nil {
+		manifest.Compression, manifest.Start = checkpoint.Compression, checkpoint.Start
+	}
+	return manifest
+}
+
+// RebuildManifest replaces the manifest loaded by LoadManifest with one
+// listing the data files found in the given backup folder, keeping what the
+// previous manifest, the _FAILURE file or the checkpoint recorded about it. When check is set,
+// every file is downloaded and verified like Verify does: the valid files are
+// listed with their checksum, size and number of items, and the invalid ones
+// are left out of the manifest. The report holds the result of the checks.
+func (h *backupBase) RebuildManifest(folder *FileInput, check bool) (*VerifyReport, error) {
+	files, err := h.dataFiles(folder)
+	if err != nil {
+		return nil, err
+	}
+	h.manifest = h.recoveredManifest(folder)
+	report := &VerifyReport{Entries: []EntryReport{}}
+	for _, file := range files {
+		entry := ManifestEntry{URL: h.store.fileURL(file), Mandatory: true}
+		if check {
+			result := h.verifyEntry(entry)
+			report.Entries = append(report.Entries, result)
+			if len(result.Errors) > 0 {
+				continue
+			}
+			entry.SHA256, entry.Size, entry.Items = result.SHA256, result.Size, result.Items
+		}
+		h.manifest.Entries = append(h.manifest.Entries, entry)
+	}
+	if len(h.manifest.Entries) == 0 {
+		return report, fmt.Errorf("no valid data file found in %s", h.store.fileURL(folder))
+	}
+	return report, nil
+}
+
+// Repair rebuilds the manifest of the given backup folder from the data files
+// it holds, leaving out the ones that can't be decoded, and writes it. The
+// staged files are moved to the folder. When complete is set and all the files
+// are valid, the backup is also flagged with _SUCCESS.
+func (h *backupBase) Repair(folder *FileInput, complete bool) (*VerifyReport, error) {
+	report, err := h.RebuildManifest(folder, true)
+	if err != nil {
+		return report, err
+	}
+	if err = h.promote(); err != nil {
+		return report, err
+	}
+	h.manifest.Totals = h.manifest.totals()
+	data, err := json.Marshal(h.manifest)
+	if err != nil {
+		return report, err
+	}
+	if err = h.store.Flush(h.folderFile(folder, "manifest"), data); err != nil {
+		return report, fmt.Errorf("while writing the manifest file: %s", err)
+	}
+	if !complete {
+		return report, nil
+	}
+	if !report.OK() {
+		report.Errors = append(report.Errors, fmt.Sprintf("not flagged with %s as some data files are invalid", successFileName))
+		return report, nil
+	}
+	if err = h.store.Flush(h.folderFile(folder, successFileName), []byte{}); err != nil {
+		return report, fmt.Errorf("while writing the %s file: %s", successFileName, err)
+	}
+	return report, nil
+}
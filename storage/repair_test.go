@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestIsDataFile(t *testing.T) {
+	testCases := map[string]bool{
+		"5f3a1b2c-0ca7-0d90-6599-f359d6a92edb":            true,
+		"5f3a1b2c-0ca7-0d90-6599-f359d6a92edb.gz":         true,
+		"_temporary/5f3a1b2c-0ca7-0d90-6599-f359d6a92edb": true,
+		"manifest":                       false,
+		"schema.json":                    false,
+		"_SUCCESS":                       false,
+		"_CHECKPOINT":                    false,
+		"2019-01-01-02-00-00/5f3a1b2c":   false,
+		"_temporary/2019-01-01/5f3a1b2c": false,
+	}
+	for relative, expected := range testCases {
+		if isDataFile(relative) != expected {
+			t.Errorf("Expecting isDataFile(%q) to be %t", relative, expected)
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	store, folder := backupTestItems(t, "mem://tests/repair")
+	for _, name := range []string{"manifest", successFileName} {
+		store.Delete(store.(*MemBackup).folderFile(folder, name))
+	}
+	corrupted := &FileInput{Bucket: folder.Bucket, Path: aws.String("repair/_temporary/corrupted")}
+	store.Flush(corrupted, []byte("not json\n"))
+	store.Flush(&FileInput{Bucket: folder.Bucket, Path: aws.String("repair/schema.json")}, []byte("{}"))
+
+	report, err := store.Repair(folder, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || len(report.Entries) != len(testItems)+1 || len(report.Errors) != 1 {
+		t.Errorf("Expecting the corrupted file to be reported, got %+v", report)
+	}
+	manifest, err := store.ReadManifest(folder)
+	if err != nil || manifest == nil || len(manifest.Entries) != len(testItems) || manifest.Totals.Items != int64(len(testItems)) {
+		t.Fatalf("Unexpected manifest: %+v, %v", manifest, err)
+	}
+	if exists, _ := store.Exists(store.(*MemBackup).folderFile(folder, successFileName)); exists {
+		t.Errorf("Expecting no _SUCCESS flag with a corrupted file")
+	}
+
+	store.Delete(corrupted)
+	if report, err = store.Repair(folder, true); err != nil || !report.OK() {
+		t.Fatalf("Unexpected repair: %+v, %v", report, err)
+	}
+	if report, err = store.Verify(folder); err != nil || !report.OK() {
+		t.Errorf("Expecting the repaired backup to be valid, got %+v, %v", report, err)
+	}
+}
+
+func TestRebuildManifest(t *testing.T) {
+	store, folder := backupTestItems(t, "mem://tests/rebuild")
+	if _, err := store.RebuildManifest(folder, false); err != nil {
+		t.Fatal(err)
+	}
+	manifest := store.(*MemBackup).manifest
+	if len(manifest.Entries) != len(testItems) || manifest.Entries[0].SHA256 != "" {
+		t.Errorf("Expecting the %d data files without check, got %+v", len(testItems), manifest.Entries)
+	}
+
+	empty := &FileInput{Bucket: folder.Bucket, Path: aws.String("rebuild/empty")}
+	if _, err := store.RebuildManifest(empty, false); err == nil {
+		t.Errorf("Expecting an error for a folder without data files")
+	}
+}
+
+func TestRepairIncremental(t *testing.T) {
+	incremental := &Incremental{Parent: "mem://tests/repair-incremental/base", Attribute: "updated_at", Since: time.Date(2019, 1, 1, 2, 0, 0, 0, time.UTC)}
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := Open("mem://tests/repair-incremental/failed", &Config{DataPipe: dataPipe, Compression: CompressionGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeTestItems(store, dataPipe, folder); err != nil {
+		t.Fatal(err)
+	}
+	store.SetManifestInfo(ManifestInfo{Incremental: incremental})
+	if err = store.Commit(folder, errors.New("interrupted")); err == nil {
+		t.Fatal("Expecting the failure to be returned")
+	}
+
+	// The failed backup is repaired from its _FAILURE file
+	if _, err = store.Repair(folder, true); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := store.ReadManifest(folder)
+	if err != nil || manifest == nil || !reflect.DeepEqual(manifest.Incremental, incremental) || manifest.Compression != CompressionGzip || manifest.Start == nil {
+		t.Fatalf("Expecting the repaired manifest to keep the parent, compression and start of the backup, got %+v, %v", manifest, err)
+	}
+
+	// Then again from its manifest
+	if _, err = store.Repair(folder, true); err != nil {
+		t.Fatal(err)
+	}
+	if repaired, err := store.ReadManifest(folder); err != nil || !reflect.DeepEqual(repaired.Incremental, incremental) || !repaired.Start.Equal(*manifest.Start) {
+		t.Errorf("Expecting the manifest to be kept by a new repair, got %+v, %v", repaired, err)
+	}
+}
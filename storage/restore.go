@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"sync"
+)
+
+// RestoreState is the progress of a restore, saved while restoring so that an
+// interrupted restore can be resumed
+type RestoreState struct {
+	TableName string `json:"tableName"`
+	// Completed holds the URLs of the manifest entries entirely written in
+	// the table
+	Completed []string `json:"completed"`
+	// Current is the URL of the manifest entry being restored and Lines is
+	// the number of its lines already written in the table
+	Current string `json:"current,omitempty"`
+	Lines   int64  `json:"lines,omitempty"`
+	// Done is set once the backup is entirely restored, for the backups of a
+	// chain of incremental backups that is not entirely restored yet
+	Done bool `json:"done,omitempty"`
+}
+
+// entryProgress keeps track of the items of a manifest entry sent to the data
+// pipe
+type entryProgress struct {
+	url string
+	// startLine is the number of lines skipped as already written
+	startLine int64
+	// firstSeq is the sequence number of the first item sent and sent the
+	// number of items sent
+	firstSeq, sent int64
+	// invalid holds, for each line that could not be decoded, the sequence
+	// number of the item sent after it
+	invalid []int64
+	// read is set once all the lines of the entry are sent
+	read bool
+}
+
+// restoreProgress keeps track of the items sent to the data pipe during a
+// restore and of the ones acknowledged as written in the table. The items are
+// numbered in the order they are sent to the data pipe.
+type restoreProgress struct {
+	mu        sync.Mutex
+	resume    RestoreState
+	completed []string
+	entries   []*entryProgress
+	sent      int64
+	// acked is the number of items written, counted from the 1st item without
+	// any gap, and pending holds the ranges acknowledged after a gap
+	acked   int64
+	pending map[int64]int64
+}
+
+// newRestoreProgress returns a restoreProgress resuming the given state
+func newRestoreProgress(resume RestoreState) *restoreProgress {
+	return &restoreProgress{resume: resume, completed: append([]string{}, resume.Completed...), pending: map[int64]int64{}}
+}
+
+// skipLines returns the number of lines of the given entry already written in
+// a previous run, -1 if the whole entry has been written
+func (p *restoreProgress) skipLines(url string) int64 {
+	for _, completed := range p.resume.Completed {
+		if completed == url {
+			return -1
+		}
+	}
+	if p.resume.Current == url {
+		return p.resume.Lines
+	}
+	return 0
+}
+
+// startEntry registers an entry of which the lines after startLine are about
+// to be sent
+func (p *restoreProgress) startEntry(url string, startLine int64) *entryProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry := &entryProgress{url: url, startLine: startLine, firstSeq: p.sent}
+	p.entries = append(p.entries, entry)
+	return entry
+}
+
+// itemSent registers an item of the entry as sent to the data pipe
+func (p *restoreProgress) itemSent(entry *entryProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry.sent++
+	p.sent++
+}
+
+// invalidLine registers a line of the entry that could not be decoded
+func (p *restoreProgress) invalidLine(entry *entryProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry.invalid = append(entry.invalid, p.sent)
+}
+
+// endEntry registers that all the lines of the entry have been sent
+func (p *restoreProgress) endEntry(entry *entryProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry.read = true
+	p.advance()
+}
+
+// acknowledge registers the count items starting at the first sequence number
+// as written in the table
+func (p *restoreProgress) acknowledge(first, count int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if first != p.acked {
+		p.pending[first] = count
+		return
+	}
+	p.acked += count
+	for next, ok := p.pending[p.acked]; ok; next, ok = p.pending[p.acked] {
+		delete(p.pending, p.acked)
+		p.acked += next
+	}
+	p.advance()
+}
+
+// advance moves the entries entirely written to the completed list. It has to
+// be called with the lock held.
+func (p *restoreProgress) advance() {
+	for len(p.entries) > 0 && p.entries[0].read && p.acked >= p.entries[0].firstSeq+p.entries[0].sent {
+		p.completed = append(p.completed, p.entries[0].url)
+		p.entries = p.entries[1:]
+	}
+}
+
+// state returns a snapshot of the progress of the restore
+func (p *restoreProgress) state() RestoreState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := RestoreState{Completed: append([]string{}, p.completed...)}
+	if len(p.entries) > 0 {
+		entry := p.entries[0]
+		written := p.acked - entry.firstSeq
+		if written > entry.sent {
+			written = entry.sent
+		}
+		lines := entry.startLine + written
+		for _, seq := range entry.invalid {
+			if seq <= p.acked {
+				lines++
+			}
+		}
+		if lines > 0 {
+			state.Current = entry.url
+			state.Lines = lines
+		}
+	}
+	return state
+}
+
+// ResumeRestore makes the next call to WriteToDB skip the data already
+// written in the table according to the given state
+func (h *backupBase) ResumeRestore(state *RestoreState) {
+	h.progress = newRestoreProgress(*state)
+}
+
+// Acknowledge registers the count items starting at the first sequence number
+// as written in the table. The items are numbered from 0 in the order they are
+// sent to the data pipe by WriteToDB.
+func (h *backupBase) Acknowledge(first, count int64) {
+	h.restoreProgress().acknowledge(first, count)
+}
+
+// RestoreState returns the progress of the restore done by WriteToDB
+func (h *backupBase) RestoreState() RestoreState {
+	return h.restoreProgress().state()
+}
+
+// restoreProgress returns the progress of the restore, creating it if needed
+func (h *backupBase) restoreProgress() *restoreProgress {
+	h.progressOnce.Do(func() {
+		if h.progress == nil {
+			h.progress = newRestoreProgress(RestoreState{})
+		}
+	})
+	return h.progress
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestRestoreProgress(t *testing.T) {
+	progress := newRestoreProgress(RestoreState{})
+	first := progress.startEntry("mem://tests/first", 0)
+	for i := 0; i < 3; i++ {
+		progress.itemSent(first)
+	}
+	progress.invalidLine(first)
+	progress.endEntry(first)
+	second := progress.startEntry("mem://tests/second", 0)
+	for i := 0; i < 3; i++ {
+		progress.itemSent(second)
+	}
+
+	// Acknowledged out of order, only the items without gap count
+	progress.acknowledge(2, 2)
+	if state := progress.state(); len(state.Completed) != 0 || state.Current != "" {
+		t.Errorf("Nothing should be written before the 1st item is acknowledged: %+v", state)
+	}
+	progress.acknowledge(0, 2)
+	expected := RestoreState{Completed: []string{"mem://tests/first"}, Current: "mem://tests/second", Lines: 1}
+	if state := progress.state(); !reflect.DeepEqual(state, expected) {
+		t.Errorf("Unexpected restore state. Expecting: %+v\nGot: %+v", expected, state)
+	}
+}
+
+func TestResumeRestore(t *testing.T) {
+	var wg sync.WaitGroup
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := Open("mem://tests/resume-restore", &Config{DataPipe: dataPipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 data files of 2 and 1 items
+	files := map[string][]map[string]*dynamodb.AttributeValue{"first": testItems[:2], "second": testItems[2:]}
+	manifest := Manifest{Version: 3, Name: "DynamoDB-export"}
+	for _, name := range []string{"first", "second"} {
+		data := []byte{}
+		for _, item := range files[name] {
+			line, _ := MarshalDynamoAttributeMap(item)
+			data = append(append(data, line...), '\n')
+		}
+		input := &FileInput{Bucket: folder.Bucket, Path: aws.String("resume-restore/" + name)}
+		if err := store.Flush(input, data); err != nil {
+			t.Fatal(err)
+		}
+		manifest.Entries = append(manifest.Entries, ManifestEntry{URL: "mem://tests/resume-restore/" + name, Mandatory: true})
+	}
+	data, _ := json.Marshal(manifest)
+	manifestFile := &FileInput{Bucket: folder.Bucket, Path: aws.String("resume-restore/manifest")}
+	if err := store.Flush(manifestFile, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LoadManifest(manifestFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// The 1st line of the 1st file was written by the previous run
+	store.ResumeRestore(&RestoreState{Current: "mem://tests/resume-restore/first", Lines: 1})
+	received := []map[string]*dynamodb.AttributeValue{}
+	done := make(chan bool)
+	go func() {
+		for elem := range dataPipe {
+			received = append(received, elem)
+		}
+		done <- true
+	}()
+	if err := store.WriteToDB("myTable", 25, 0, &wg); err != nil {
+		t.Fatalf("Unable to read the backup: %s", err)
+	}
+	<-done
+	if !reflect.DeepEqual(received, testItems[1:]) {
+		t.Errorf("Expecting only the items not written yet. Got: %v", received)
+	}
+
+	store.Acknowledge(0, 1)
+	expected := RestoreState{Completed: []string{"mem://tests/resume-restore/first"}}
+	if state := store.RestoreState(); !reflect.DeepEqual(state, expected) {
+		t.Errorf("Unexpected restore state. Expecting: %+v\nGot: %+v", expected, state)
+	}
+	store.Acknowledge(1, 1)
+	expected.Completed = append(expected.Completed, "mem://tests/resume-restore/second")
+	if state := store.RestoreState(); !reflect.DeepEqual(state, expected) {
+		t.Errorf("Unexpected restore state. Expecting: %+v\nGot: %+v", expected, state)
+	}
+}
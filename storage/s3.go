@@ -1,20 +1,17 @@
 package storage
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/url"
-	"sync"
-	"time"
+	"sort"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -23,36 +20,36 @@ import (
 
 // S3Backup is the structure that implements the backup storage for the S3 backend
 type S3Backup struct {
-	BackupIface
-	manifest Manifest
+	backupBase
 	client   s3iface.S3API
 	uploader s3manageriface.UploaderAPI
-	DataPipe chan map[string]*dynamodb.AttributeValue
 }
 
-// NewS3Backup initlialiaes the s3 client and returns a pointer to a S3Backup struct
-func NewS3Backup(sess client.ConfigProvider) *S3Backup {
-	return &S3Backup{client: s3.New(sess), uploader: s3manager.NewUploader(sess)}
+// NewS3Backup initlialiaes the s3 client and returns a pointer to a S3Backup struct.
+// The given configs override the ones of the session.
+func NewS3Backup(sess client.ConfigProvider, cfgs ...*aws.Config) *S3Backup {
+	svc := s3.New(sess, cfgs...)
+	b := &S3Backup{client: svc, uploader: s3manager.NewUploaderWithClient(svc)}
+	b.store = b
+	return b
 }
 
-// LoadManifest downloads the given manifest file and load it in the
-// Manifest attribute of the struct
-func (h *S3Backup) LoadManifest(input *FileInput) error {
-	doc, err := h.GetFile(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
-			log.Printf("[ERROR] Unable to find a manifest flag in the provided folder. Are you sure the backup was successful?\nAborting...\n")
-		}
-		log.Printf("[ERROR] Unable to retrieve the manifest flag information: %s\nAborting...\n", err)
-		return err
+func init() {
+	Register("s3", openS3Backup)
+}
+
+// openS3Backup is the Constructor of the s3:// URLs. The host of the URL is the
+// bucket and its path the folder inside the bucket.
+func openS3Backup(u *url.URL, cfg *Config) (BackupIface, *FileInput, error) {
+	if cfg.Session == nil {
+		return nil, nil, fmt.Errorf("an AWS session is required to use %s", u)
 	}
-	defer Close(*doc)
-	buff := bytes.NewBuffer(nil)
-	if _, err := io.Copy(buff, *doc); err != nil {
-		return err
+	if u.Host == "" {
+		return nil, nil, fmt.Errorf("no bucket provided in %s", u)
 	}
-
-	return json.Unmarshal(buff.Bytes(), &h.manifest)
+	b := NewS3Backup(cfg.Session, cfg.S3Config)
+	b.configure(cfg)
+	return b, &FileInput{Bucket: aws.String(u.Host), Path: aws.String(strings.TrimPrefix(u.Path, "/"))}, nil
 }
 
 // GetFile downloads a file from s3 to memory (as the files are small by
@@ -104,94 +101,89 @@ func (h *S3Backup) Flush(input *FileInput, data []byte) error {
 	return err
 }
 
-// Scan reads the data from a backup line by line, serializes it and
-// sends it to the struct's channel
-func (h *S3Backup) Scan(dataReader *io.ReadCloser) error {
-	defer Close(*dataReader)
-	scanner := bufio.NewScanner(*dataReader)
-	for scanner.Scan() {
-		res := map[string]*dynamodb.AttributeValue{}
-		data := scanner.Bytes()
-		if err := json.Unmarshal(data[:], &res); err != nil {
-			log.Printf("[Error] unmashaling %v: %s", data, err)
-		} else {
-			h.DataPipe <- res
-		}
-	}
-	return scanner.Err()
+// Delete removes the given file from s3
+func (h *S3Backup) Delete(input *FileInput) error {
+	_, err := h.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: input.Bucket,
+		Key:    input.Path,
+	})
+	return err
 }
 
-// WriteToDB pulls the s3 files from S3Backup.manifest and import them
-// inside the given table using the given batch size (and wait period between
-// each batch)
-func (h *S3Backup) WriteToDB(tableName string, batchSize int64, waitPeriod time.Duration, wg *sync.WaitGroup) error {
-	wg.Add(1)
-	for _, entry := range h.manifest.Entries {
-		u, _ := url.Parse(entry.URL)
-		if u.Scheme == "s3" {
-			data, err := h.GetFile(&FileInput{Bucket: &u.Host, Path: &u.Path})
-			if err != nil {
-				return err
-			}
-			if err = h.Scan(data); err != nil {
-				return err
-			}
+// ListFolders returns the sorted names of the common prefixes found right
+// under the given folder
+func (h *S3Backup) ListFolders(folder *FileInput) ([]string, error) {
+	prefix := strings.TrimSuffix(*folder.Path, "/") + "/"
+	if prefix == "/" {
+		prefix = ""
+	}
+	folders := []string{}
+	err := h.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    folder.Bucket,
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, common := range page.CommonPrefixes {
+			folders = append(folders, strings.TrimSuffix(strings.TrimPrefix(*common.Prefix, prefix), "/"))
 		}
+		return true
+	})
+	sort.Strings(folders)
+	return folders, err
+}
 
+// ListFiles returns the files found under the given folder and its
+// sub-folders, sorted by key
+func (h *S3Backup) ListFiles(folder *FileInput) ([]*FileInput, error) {
+	prefix := strings.TrimSuffix(*folder.Path, "/") + "/"
+	if prefix == "/" {
+		prefix = ""
 	}
-	close(h.DataPipe)
+	files := []*FileInput{}
+	err := h.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: folder.Bucket,
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			files = append(files, &FileInput{Bucket: folder.Bucket, Path: object.Key})
+		}
+		return true
+	})
+	return files, err
+}
+
+// removeFolder does nothing as the folders only exist through the keys of
+// their files in s3
+func (h *S3Backup) removeFolder(folder *FileInput) error {
 	return nil
 }
 
-// DumpBuffer dumps the content of the given buffer to a new randomly generated
-// file name in the given s3 path in the given bucket and resets the said buffer
-func (h *S3Backup) DumpBuffer(input *FileInput, buff *bytes.Buffer) {
-	*input.Path = fmt.Sprintf("%s/%s", *input.Path, genNewFileName())
-	if err := h.Flush(input, buff.Bytes()); err != nil {
-		log.Printf("[ERROR] while writing the file %s: %s", *input.Path, err)
+// move copies the given file to its new key and removes the original. The
+// copy keeps the storage class and the encryption of the uploaded files.
+func (h *S3Backup) move(from, to *FileInput) error {
+	_, err := h.client.CopyObject(&s3.CopyObjectInput{
+		Bucket:               to.Bucket,
+		Key:                  to.Path,
+		CopySource:           aws.String((&url.URL{Path: fmt.Sprintf("%s/%s", *from.Bucket, *from.Path)}).EscapedPath()),
+		StorageClass:         aws.String("STANDARD_IA"),
+		ServerSideEncryption: aws.String("AES256"),
+	})
+	if err != nil {
+		return err
 	}
-	h.manifest.Entries = append(h.manifest.Entries, ManifestEntry{URL: fmt.Sprintf("s3://%s/%s", *input.Bucket, *input.Path), Mandatory: true})
-	buff.Reset()
+	log.Printf("Moved file: s3://%s/%s to s3://%s/%s\n", *from.Bucket, *from.Path, *to.Bucket, *to.Path)
+	return h.Delete(from)
 }
 
-// Write reads from the given channel and sends the data the given bucket
-// in files of s3BufferSize max size
-func (h *S3Backup) Write(input *FileInput, s3BufferSize int, wg *sync.WaitGroup) {
-	defer wg.Done()
-	// buff is the buffer where the data will be stored while before being sent to s3
-	var buff bytes.Buffer
-	h.manifest = Manifest{Version: 3, Name: "DynamoDB-export"}
-	s3Folder := *input.Path
-
-	for elem := range h.DataPipe {
-		data, err := MarshalDynamoAttributeMap(elem)
-		if err != nil {
-			log.Fatalf("[ERROR] while converting to json: %v\nError: %s\n", elem, err)
-		}
-
-		// before overflowing the buffer, dump to s3 and empty it
-		if buff.Len()+len(data) >= s3BufferSize && buff.Len() > 0 {
-			h.DumpBuffer(input, &buff)
-		}
-		// add the data to the buffer
-		buff.Write(data)
-		buff.WriteString("\n")
-	}
+// fileURL returns the s3:// URL of the given file
+func (h *S3Backup) fileURL(input *FileInput) string {
+	return fmt.Sprintf("s3://%s/%s", *input.Bucket, *input.Path)
+}
 
-	// Upload the rest of the buffer
-	h.DumpBuffer(input, &buff)
-	// Wrap up the manifest of the backup files
-	manifestData, err := json.Marshal(h.manifest)
-	if err != nil {
-		log.Fatalf("[ERROR] while marshaling the manifest: %v\nError: %s\n", h.manifest, err)
-	}
-	m := FileInput{Bucket: input.Bucket, Path: aws.String(fmt.Sprintf("%s/manifest", s3Folder))}
-	if err = h.Flush(&m, manifestData); err != nil {
-		log.Printf("[ERROR] while writing the manifest file: %s", err)
-	}
-	// Signal the success of the backup
-	s := FileInput{Bucket: input.Bucket, Path: aws.String(fmt.Sprintf("%s/_SUCCESS", s3Folder))}
-	if err = h.Flush(&s, []byte{}); err != nil {
-		log.Printf("[ERROR] while writing the _SUCCESS file: %s", err)
+// urlToFileInput returns the FileInput matching the given s3:// URL
+func (h *S3Backup) urlToFileInput(u *url.URL) *FileInput {
+	if u.Scheme != "s3" {
+		return nil
 	}
+	return &FileInput{Bucket: aws.String(u.Host), Path: aws.String(strings.TrimPrefix(u.Path, "/"))}
 }
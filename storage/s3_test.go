@@ -1,9 +1,42 @@
 package storage
 
 import (
+	"reflect"
 	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
+// struct to mock the s3 calls
+type mockS3Client struct {
+	s3iface.S3API
+	listed *s3.ListObjectsV2Input
+}
+
+func (m *mockS3Client) ListObjectsV2Pages(input *s3.ListObjectsV2Input, pager func(*s3.ListObjectsV2Output, bool) bool) error {
+	m.listed = input
+	if !pager(&s3.ListObjectsV2Output{CommonPrefixes: []*s3.CommonPrefix{{Prefix: aws.String("backups/myTable/2019-01-02-02-00-00/")}}}, false) {
+		return nil
+	}
+	pager(&s3.ListObjectsV2Output{CommonPrefixes: []*s3.CommonPrefix{{Prefix: aws.String("backups/myTable/2019-01-01-02-00-00/")}}}, true)
+	return nil
+}
+
+func TestS3ListFolders(t *testing.T) {
+	client := &mockS3Client{}
+	store := &S3Backup{client: client}
+	store.store = store
+	folders, err := store.ListFolders(&FileInput{Bucket: aws.String("bucket"), Path: aws.String("backups/myTable")})
+	if err != nil || !reflect.DeepEqual(folders, []string{"2019-01-01-02-00-00", "2019-01-02-02-00-00"}) {
+		t.Errorf("Unexpected folders: %v, %v", folders, err)
+	}
+	if *client.listed.Prefix != "backups/myTable/" || *client.listed.Delimiter != "/" {
+		t.Errorf("Unexpected listing: %v", client.listed)
+	}
+}
+
 func TestDummy(t *testing.T) {
 	if 1 == 2 {
 		t.Errorf("Placeholder")
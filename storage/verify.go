@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+)
+
+// EntryReport is the result of the verification of a manifest entry
+type EntryReport struct {
+	URL    string   `json:"url"`
+	Size   int64    `json:"size"`
+	Items  int64    `json:"items"`
+	SHA256 string   `json:"sha256"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// VerifyReport is the result of the verification of a backup
+type VerifyReport struct {
+	Entries []EntryReport `json:"entries"`
+	// Errors are the problems found on the backup as a whole
+	Errors []string `json:"errors,omitempty"`
+}
+
+// OK returns whether no problem has been found in the backup
+func (r *VerifyReport) OK() bool {
+	if len(r.Errors) > 0 {
+		return false
+	}
+	for _, entry := range r.Entries {
+		if len(entry.Errors) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify downloads every file listed in the manifest of the given backup
+// folder and checks their checksum, size and number of items against the
+// manifest. It also checks that every line decodes to a DynamoDB item. The
+// checks of the values absent from the manifest are skipped. An error is only
+// returned if the manifest can't be loaded.
+func (h *backupBase) Verify(folder *FileInput) (*VerifyReport, error) {
+	report := &VerifyReport{Entries: []EntryReport{}}
+	if exists, err := h.store.Exists(h.folderFile(folder, successFileName)); err != nil || !exists {
+		if err == nil {
+			err = fmt.Errorf("not found")
+		}
+		report.Errors = append(report.Errors, fmt.Sprintf("%s flag: %s", successFileName, err))
+	}
+	if err := h.LoadManifest(h.folderFile(folder, "manifest")); err != nil {
+		return nil, err
+	}
+
+	var size, items int64
+	for _, entry := range h.manifest.Entries {
+		result := h.verifyEntry(entry)
+		size += result.Size
+		items += result.Items
+		report.Entries = append(report.Entries, result)
+	}
+
+	if totals := h.manifest.Totals; totals != nil {
+		if totals.Files != len(h.manifest.Entries) {
+			report.Errors = append(report.Errors, fmt.Sprintf("expecting %d files, the manifest lists %d", totals.Files, len(h.manifest.Entries)))
+		}
+		if totals.Size != size {
+			report.Errors = append(report.Errors, fmt.Sprintf("expecting a total of %d bytes, got %d", totals.Size, size))
+		}
+		if totals.Items != items {
+			report.Errors = append(report.Errors, fmt.Sprintf("expecting a total of %d items, got %d", totals.Items, items))
+		}
+	}
+	return report, nil
+}
+
+// verifyEntry downloads the file of the given manifest entry and checks it
+func (h *backupBase) verifyEntry(entry ManifestEntry) EntryReport {
+	result := EntryReport{URL: entry.URL}
+	fail := func(format string, args ...interface{}) EntryReport {
+		result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+		return result
+	}
+
+	u, err := url.Parse(entry.URL)
+	if err != nil {
+		return fail("invalid URL: %s", err)
+	}
+	input := h.store.urlToFileInput(u)
+	if input == nil {
+		return fail("the URL is not handled by this storage")
+	}
+	doc, err := h.store.GetFile(input)
+	if err != nil {
+		return fail("unable to download: %s", err)
+	}
+	defer Close(*doc)
+	data, err := ioutil.ReadAll(*doc)
+	if err != nil {
+		return fail("unable to download: %s", err)
+	}
+
+	result.Size = int64(len(data))
+	if entry.Size > 0 && entry.Size != result.Size {
+		fail("expecting %d bytes, got %d", entry.Size, result.Size)
+	}
+	checksum := sha256.Sum256(data)
+	result.SHA256 = hex.EncodeToString(checksum[:])
+	if entry.SHA256 != "" && entry.SHA256 != result.SHA256 {
+		fail("checksum mismatch, expecting %s, got %s", entry.SHA256, result.SHA256)
+	}
+
+	reader, err := decompressReader(h.manifest.Compression, bytes.NewReader(data))
+	if err != nil {
+		return fail("unable to decompress: %s", err)
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer Close(closer)
+	}
+	scanner := newLineScanner(reader)
+	for scanner.Scan() {
+		result.Items++
+		if err := decodeItem(scanner.Bytes()); err != nil {
+			fail("line %d: %s", result.Items, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fail("unable to read line %d: %s", result.Items+1, err)
+	}
+	if entry.Items > 0 && entry.Items != result.Items {
+		fail("expecting %d items, got %d", entry.Items, result.Items)
+	}
+	return result
+}
+
+// decodeItem checks that the given line decodes to a DynamoDB item through
+// CustomAttributeValue
+func decodeItem(line []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+	item := map[string]*CustomAttributeValue{}
+	if err := decoder.Decode(&item); err != nil {
+		return err
+	}
+	if len(item) == 0 {
+		return fmt.Errorf("empty item")
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// backupTestItems writes and commits a backup of the test items in the given
+// mem:// folder, one file per item
+func backupTestItems(t *testing.T, location string) (BackupIface, *FileInput) {
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := Open(location, &Config{DataPipe: dataPipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeTestItems(store, dataPipe, folder); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Commit(folder, nil); err != nil {
+		t.Fatal(err)
+	}
+	return store, folder
+}
+
+func TestVerify(t *testing.T) {
+	store, folder := backupTestItems(t, "mem://tests/verify")
+	report, err := store.Verify(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || len(report.Entries) != len(testItems) {
+		t.Fatalf("Expecting a valid backup of %d files, got %+v", len(testItems), report)
+	}
+	manifest := store.(*MemBackup).manifest
+	if manifest.Totals == nil || manifest.Totals.Items != int64(len(testItems)) || manifest.Totals.Files != len(testItems) {
+		t.Errorf("Unexpected totals in the manifest: %+v", manifest.Totals)
+	}
+	for _, entry := range manifest.Entries {
+		if len(entry.SHA256) != 64 || entry.Size == 0 || entry.Items != 1 {
+			t.Errorf("Unexpected manifest entry: %+v", entry)
+		}
+	}
+
+	// Corrupts the files of the backup
+	files := []*FileInput{}
+	for _, entry := range manifest.Entries {
+		u, _ := url.Parse(entry.URL)
+		files = append(files, store.(*MemBackup).urlToFileInput(u))
+	}
+	store.Flush(files[0], []byte(`{"artist":{"s":"Aerosmith"},"year":{"n":"1974"}}`+"\n"))
+	store.Flush(files[1], []byte(`{"artist":{"unknown":"Queen"}}`+"\n"))
+	store.Delete(files[2])
+	store.Delete(&FileInput{Bucket: folder.Bucket, Path: aws.String("verify/_SUCCESS")})
+
+	report, err = store.Verify(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Fatalf("Expecting the corruption to be detected")
+	}
+	expected := []string{"checksum mismatch", "line 1: json: unknown field", "unable to download"}
+	for idx, entry := range report.Entries {
+		if len(entry.Errors) == 0 || !strings.Contains(strings.Join(entry.Errors, "\n"), expected[idx]) {
+			t.Errorf("Expecting %q for %s, got %v", expected[idx], entry.URL, entry.Errors)
+		}
+	}
+	// The _SUCCESS flag and the total size are reported as well
+	if len(report.Errors) != 3 {
+		t.Errorf("Expecting errors on the _SUCCESS flag and the totals, got %v", report.Errors)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	store, folder, err := Open("mem://tests/longlines", &Config{DataPipe: dataPipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		errs <- store.Write(folder, 1024, &wg)
+	}()
+	dataPipe <- map[string]*dynamodb.AttributeValue{"artist": {S: aws.String(strings.Repeat("a", 300*1024))}}
+	close(dataPipe)
+	wg.Wait()
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Commit(folder, nil); err != nil {
+		t.Fatal(err)
+	}
+	report, err := store.Verify(folder)
+	if err != nil || !report.OK() {
+		t.Errorf("Expecting lines longer than 64KB to be read, got %+v, %v", report, err)
+	}
+}
+
+func TestDecodeDatapipelineItem(t *testing.T) {
+	line := []byte(`{"artist":{"s":"Queen"},"songs":{"sS":["Under pressure"]},"label":{"nULLValue":true},"active":{"bOOL":true},"members":{"l":[{"nULLValue":true}]}}`)
+	if err := decodeItem(line); err != nil {
+		t.Fatalf("Expecting an export of the AWS datapipelines to be valid, got %s", err)
+	}
+	item, err := UnmarshalDynamoAttributeMap(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !aws.BoolValue(item["label"].NULL) || !aws.BoolValue(item["members"].L[0].NULL) || !aws.BoolValue(item["active"].BOOL) || len(item["songs"].SS) != 1 {
+		t.Errorf("Unexpected item: %v", item)
+	}
+	if err = decodeItem([]byte(`{"artist":{"unknown":"Queen"}}`)); err == nil {
+		t.Error("Expecting an error for an unknown type")
+	}
+}
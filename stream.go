@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+)
+
+// streamCheckpointFileName is the name of the file of the change archive
+// folder holding the position reached in each shard of the stream
+const streamCheckpointFileName = "_STREAM_CHECKPOINT"
+
+// streamShardPages is the number of pages of records read from a shard before
+// moving to the next one, so that a shard with continuous writes does not
+// prevent the others from being read
+const streamShardPages = 100
+
+// shardPosition is the position reached in a shard of a stream
+type shardPosition struct {
+	// Sequence is the sequence number of the last archived record
+	Sequence string `json:"sequence,omitempty"`
+	// Closed is set once all the records of the shard are archived
+	Closed bool `json:"closed,omitempty"`
+}
+
+// streamCheckpoint is saved in the change archive folder after each segment
+// so that the archiving can be resumed
+type streamCheckpoint struct {
+	StreamARN string                   `json:"streamArn"`
+	Shards    map[string]shardPosition `json:"shards"`
+}
+
+// streamSegment is a date folder of the change archive being written
+type streamSegment struct {
+	url       string
+	folder    *storage.FileInput
+	store     storage.BackupIface
+	dataPipe  chan map[string]*dynamodb.AttributeValue
+	wg        sync.WaitGroup
+	writeErrs chan error
+	start     time.Time
+	changes   storage.ChangeSet
+	records   int64
+}
+
+// streamArchiver archives the records of the stream of a table in segments,
+// each written in a date folder of the archive folder like a backup
+type streamArchiver struct {
+	svc       dynamodbstreamsiface.DynamoDBStreamsAPI
+	streamARN string
+	// location is the URL of the archive folder and cfg the configuration
+	// of the storage of each segment
+	location      string
+	cfg           storage.Config
+	store         storage.BackupIface
+	folder        *storage.FileInput
+	segmentPeriod time.Duration
+	checkpoint    streamCheckpoint
+	// dirty is set when the checkpoint changed without any segment to save
+	// it with
+	dirty bool
+	// iterators are the shard iterators to continue reading the open shards
+	// from
+	iterators map[string]*string
+	// shardPages is the number of pages read from a shard on each pass
+	shardPages int
+	segment    *streamSegment
+}
+
+// tableStream returns the ARN of the stream of the given table, which has to
+// hold the new images of the items
+func tableStream(svc dynamodbiface.DynamoDBAPI, tableName string) (string, error) {
+	var result *dynamodb.DescribeTableOutput
+	err := retry.do(func() (err error) {
+		result, err = svc.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	spec := result.Table.StreamSpecification
+	if result.Table.LatestStreamArn == nil || spec == nil || !aws.BoolValue(spec.StreamEnabled) {
+		return "", fmt.Errorf("the table %s has no stream enabled", tableName)
+	}
+	switch aws.StringValue(spec.StreamViewType) {
+	case dynamodb.StreamViewTypeNewImage, dynamodb.StreamViewTypeNewAndOldImages:
+	default:
+		return "", fmt.Errorf("the stream of the table %s has to hold the new images of the items, not %s", tableName, aws.StringValue(spec.StreamViewType))
+	}
+	return *result.Table.LatestStreamArn, nil
+}
+
+// recordItem returns the item of the change archive holding the given stream
+// record: its new image or, once removed, its key
+func recordItem(record *dynamodbstreams.Record) (map[string]*dynamodb.AttributeValue, storage.Change) {
+	change := storage.Change{
+		Event:    aws.StringValue(record.EventName),
+		Time:     aws.TimeValue(record.Dynamodb.ApproximateCreationDateTime).UTC(),
+		Sequence: aws.StringValue(record.Dynamodb.SequenceNumber),
+	}
+	for name := range record.Dynamodb.Keys {
+		change.Keys = append(change.Keys, name)
+	}
+	image := record.Dynamodb.NewImage
+	if change.Event == storage.EventRemove {
+		image = record.Dynamodb.Keys
+	}
+	return storage.MarkChange(image, change), change
+}
+
+// archiveStream archives the records of the stream of the given table in
+// segments of the given period written in date folders of the given location,
+// until interrupted or, when once is set, until all the available records are
+// archived. The position reached in each shard is saved after each segment so
+// that the next run continues from it.
+func archiveStream(svc dynamodbstreamsiface.DynamoDBStreamsAPI, tableName, location string, cfg storage.Config, segmentPeriod, pollPeriod time.Duration, once bool, store storage.BackupIface, folder *storage.FileInput) error {
+	streamARN, err := tableStream(dynamoSvc, tableName)
+	if err != nil {
+		return err
+	}
+	a := &streamArchiver{svc: svc, streamARN: streamARN, location: location, cfg: cfg, store: store, folder: folder, segmentPeriod: segmentPeriod, iterators: map[string]*string{}, shardPages: streamShardPages}
+	if err = a.loadCheckpoint(); err != nil {
+		return fmt.Errorf("unable to load the checkpoint of the stream: %s", err)
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+	go func() {
+		if _, ok := <-signals; ok {
+			log.Println("Stopping the archiving of the stream once the current segment is written")
+			close(stop)
+		}
+	}()
+
+	log.Printf("Archiving the stream %s of the table %s to %s\n", streamARN, tableName, location)
+	for {
+		changed, err := a.readShards(stop)
+		if err != nil {
+			// Keeps the changes already read
+			if commitErr := a.commitSegment(); commitErr != nil {
+				log.Printf("[ERROR] %s\n", commitErr)
+			}
+			return err
+		}
+		if isStopped(stop) || (once && !changed) {
+			return a.commitSegment()
+		}
+		if err = a.rollSegment(); err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return a.commitSegment()
+		case <-time.After(pollPeriod):
+		}
+	}
+}
+
+// isStopped returns whether the given channel is closed
+func isStopped(stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkpointFile returns the file of the checkpoint of the stream
+func (a *streamArchiver) checkpointFile() *storage.FileInput {
+	return &storage.FileInput{Bucket: a.folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *a.folder.Path, streamCheckpointFileName))}
+}
+
+// loadCheckpoint loads the position reached in each shard by the previous run
+func (a *streamArchiver) loadCheckpoint() error {
+	a.checkpoint = streamCheckpoint{StreamARN: a.streamARN, Shards: map[string]shardPosition{}}
+	file := a.checkpointFile()
+	if exists, err := a.store.Exists(file); err != nil || !exists {
+		return err
+	}
+	doc, err := a.store.GetFile(file)
+	if err != nil {
+		return err
+	}
+	defer storage.Close(*doc)
+	data, err := ioutil.ReadAll(*doc)
+	if err != nil {
+		return err
+	}
+	checkpoint := streamCheckpoint{}
+	if err = json.Unmarshal(data, &checkpoint); err != nil {
+		return err
+	}
+	if checkpoint.StreamARN != a.streamARN {
+		log.Printf("[WARNING] The checkpoint is for the stream %s, starting from the oldest records of %s\n", checkpoint.StreamARN, a.streamARN)
+		return nil
+	}
+	if checkpoint.Shards != nil {
+		a.checkpoint.Shards = checkpoint.Shards
+	}
+	return nil
+}
+
+// saveCheckpoint writes the position reached in each shard
+func (a *streamArchiver) saveCheckpoint() error {
+	data, err := json.Marshal(a.checkpoint)
+	if err != nil {
+		return err
+	}
+	if err = a.store.Flush(a.checkpointFile(), data); err != nil {
+		return fmt.Errorf("unable to write the checkpoint of the stream: %s", err)
+	}
+	a.dirty = false
+	return nil
+}
+
+// listShards returns the shards of the stream. The positions of the shards
+// that are not listed anymore are removed from the checkpoint.
+func (a *streamArchiver) listShards() ([]*dynamodbstreams.Shard, error) {
+	shards := []*dynamodbstreams.Shard{}
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(a.streamARN)}
+	for {
+		var output *dynamodbstreams.DescribeStreamOutput
+		err := retry.do(func() (err error) {
+			output, err = a.svc.DescribeStream(input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, output.StreamDescription.Shards...)
+		if output.StreamDescription.LastEvaluatedShardId == nil {
+			break
+		}
+		input.ExclusiveStartShardId = output.StreamDescription.LastEvaluatedShardId
+	}
+	listed := map[string]bool{}
+	for _, shard := range shards {
+		listed[aws.StringValue(shard.ShardId)] = true
+	}
+	for id := range a.checkpoint.Shards {
+		if !listed[id] {
+			delete(a.checkpoint.Shards, id)
+			a.dirty = true
+		}
+	}
+	return shards, nil
+}
+
+// readShards reads the available records of the shards that are not closed
+// and whose parent is done, so that the changes of an item are archived in
+// order. It returns whether any record has been read or any shard closed.
+func (a *streamArchiver) readShards(stop chan struct{}) (bool, error) {
+	shards, err := a.listShards()
+	if err != nil {
+		return false, fmt.Errorf("unable to describe the stream: %s", err)
+	}
+	listed := map[string]bool{}
+	for _, shard := range shards {
+		listed[aws.StringValue(shard.ShardId)] = true
+	}
+	changed := false
+	for _, shard := range shards {
+		if isStopped(stop) {
+			break
+		}
+		id, parent := aws.StringValue(shard.ShardId), aws.StringValue(shard.ParentShardId)
+		if a.checkpoint.Shards[id].Closed || (listed[parent] && !a.checkpoint.Shards[parent].Closed) {
+			continue
+		}
+		read, err := a.readShard(id, stop)
+		if err != nil {
+			return changed, fmt.Errorf("unable to read the shard %s: %s", id, err)
+		}
+		changed = changed || read > 0 || a.checkpoint.Shards[id].Closed
+	}
+	if a.dirty && a.segment == nil {
+		return changed, a.saveCheckpoint()
+	}
+	return changed, nil
+}
+
+// shardIterator returns an iterator on the records of the given shard
+// following the last archived one
+func (a *streamArchiver) shardIterator(id string) (*string, error) {
+	input := &dynamodbstreams.GetShardIteratorInput{StreamArn: aws.String(a.streamARN), ShardId: aws.String(id), ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon)}
+	if sequence := a.checkpoint.Shards[id].Sequence; sequence != "" {
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber)
+		input.SequenceNumber = aws.String(sequence)
+	}
+	var output *dynamodbstreams.GetShardIteratorOutput
+	err := retry.do(func() (err error) {
+		output, err = a.svc.GetShardIterator(input)
+		return err
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodbstreams.ErrCodeTrimmedDataAccessException && input.SequenceNumber != nil {
+		log.Printf("[WARNING] The records of the shard %s following %s are not available anymore, some changes are missing from the archive\n", id, *input.SequenceNumber)
+		input.ShardIteratorType, input.SequenceNumber = aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon), nil
+		err = retry.do(func() (err error) {
+			output, err = a.svc.GetShardIterator(input)
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return output.ShardIterator, nil
+}
+
+// readShard archives the available records of the given shard, up to
+// shardPages pages, and returns their number. The shard is flagged as closed
+// once all its records are read.
+func (a *streamArchiver) readShard(id string, stop chan struct{}) (int, error) {
+	iterator := a.iterators[id]
+	if iterator == nil {
+		var err error
+		if iterator, err = a.shardIterator(id); err != nil {
+			return 0, err
+		}
+	}
+	read := 0
+	for pages := 0; iterator != nil && pages < a.shardPages && !isStopped(stop); pages++ {
+		var output *dynamodbstreams.GetRecordsOutput
+		err := retry.do(func() (err error) {
+			output, err = a.svc.GetRecords(&dynamodbstreams.GetRecordsInput{ShardIterator: iterator})
+			return err
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodbstreams.ErrCodeExpiredIteratorException {
+			if iterator, err = a.shardIterator(id); err != nil {
+				return read, err
+			}
+			continue
+		}
+		if err != nil {
+			return read, err
+		}
+		for _, record := range output.Records {
+			if err = a.archive(id, record); err != nil {
+				return read, err
+			}
+		}
+		read += len(output.Records)
+		iterator = output.NextShardIterator
+		if err = a.rollSegment(); err != nil {
+			return read, err
+		}
+		if len(output.Records) == 0 {
+			break
+		}
+	}
+	a.iterators[id] = iterator
+	if iterator == nil {
+		delete(a.iterators, id)
+		position := a.checkpoint.Shards[id]
+		position.Closed = true
+		a.checkpoint.Shards[id] = position
+		a.dirty = true
+	}
+	return read, nil
+}
+
+// archive sends the given record of the given shard to the current segment
+func (a *streamArchiver) archive(shard string, record *dynamodbstreams.Record) error {
+	if record.Dynamodb == nil {
+		return nil
+	}
+	if a.segment == nil {
+		if err := a.openSegment(); err != nil {
+			return err
+		}
+	}
+	item, change := recordItem(record)
+	a.segment.dataPipe <- item
+	a.segment.records++
+	if a.segment.records == 1 || change.Time.Before(a.segment.changes.First) {
+		a.segment.changes.First = change.Time
+	}
+	if change.Time.After(a.segment.changes.Last) {
+		a.segment.changes.Last = change.Time
+	}
+	a.checkpoint.Shards[shard] = shardPosition{Sequence: change.Sequence}
+	return nil
+}
+
+// openSegment starts writing a new segment in a date folder of the archive
+// folder
+func (a *streamArchiver) openSegment() error {
+	now := time.Now().UTC()
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	cfg := a.cfg
+	cfg.DataPipe, cfg.Checkpointer = dataPipe, nil
+	store, folder, err := storage.Open(a.location, &cfg)
+	if err != nil {
+		return err
+	}
+	prefix := *folder.Path
+	for {
+		folder.Path = aws.String(fmt.Sprintf("%s/%s", prefix, now.Format(storage.DateFolderFormat)))
+		// Never writes in the folder of a previous segment
+		exists, err := store.Exists(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String(*folder.Path + "/manifest")})
+		if err != nil {
+			return err
+		}
+		if !exists {
+			break
+		}
+		time.Sleep(time.Until(now.Truncate(time.Second).Add(time.Second)))
+		now = time.Now().UTC()
+	}
+
+	a.segment = &streamSegment{
+		url:       strings.TrimSuffix(a.location, "/") + "/" + now.Format(storage.DateFolderFormat),
+		folder:    folder,
+		store:     store,
+		dataPipe:  dataPipe,
+		writeErrs: make(chan error, 1),
+		start:     now,
+		changes:   storage.ChangeSet{StreamARN: a.streamARN},
+	}
+	a.segment.wg.Add(1)
+	go func(segment *streamSegment) {
+		segment.writeErrs <- segment.store.Write(segment.folder, 10*1024*1024, &segment.wg)
+	}(a.segment)
+	return nil
+}
+
+// rollSegment commits the current segment once its period is over
+func (a *streamArchiver) rollSegment() error {
+	if a.segment == nil || time.Since(a.segment.start) < a.segmentPeriod {
+		return nil
+	}
+	return a.commitSegment()
+}
+
+// commitSegment completes the current segment, if any, and saves the
+// checkpoint
+func (a *streamArchiver) commitSegment() error {
+	segment := a.segment
+	if segment == nil {
+		if a.dirty {
+			return a.saveCheckpoint()
+		}
+		return nil
+	}
+	a.segment = nil
+	close(segment.dataPipe)
+	segment.wg.Wait()
+	failure := <-segment.writeErrs
+	segment.store.SetManifestInfo(storage.ManifestInfo{Changes: &segment.changes})
+	if err := segment.store.Commit(segment.folder, failure); err != nil {
+		return fmt.Errorf("unable to write the segment %s: %s", segment.url, err)
+	}
+	log.Printf("Archived %d changes to %s\n", segment.records, segment.url)
+	return a.saveCheckpoint()
+}
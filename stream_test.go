@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+)
+
+// struct to mock the DescribeTable call of a table with a stream
+type mockStreamTableClient struct {
+	dynamodbiface.DynamoDBAPI
+	viewType string
+}
+
+func (m *mockStreamTableClient) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	table := &dynamodb.TableDescription{TableName: input.TableName}
+	if m.viewType != "" {
+		table.LatestStreamArn = aws.String("arn:aws:dynamodb:us-east-1:123456789012:table/myTable/stream/1")
+		table.StreamSpecification = &dynamodb.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: aws.String(m.viewType)}
+	}
+	return &dynamodb.DescribeTableOutput{Table: table}, nil
+}
+
+// struct to mock the DynamoDB Streams calls. The shard iterators are the id of
+// the shard followed by the index of the next record.
+type mockStreamsClient struct {
+	dynamodbstreamsiface.DynamoDBStreamsAPI
+	shards  []*dynamodbstreams.Shard
+	records map[string][]*dynamodbstreams.Record
+	closed  map[string]bool
+}
+
+func (m *mockStreamsClient) DescribeStream(input *dynamodbstreams.DescribeStreamInput) (*dynamodbstreams.DescribeStreamOutput, error) {
+	// Returns a shard per page
+	idx := 0
+	for input.ExclusiveStartShardId != nil && *m.shards[idx].ShardId != *input.ExclusiveStartShardId {
+		idx++
+	}
+	if input.ExclusiveStartShardId != nil {
+		idx++
+	}
+	description := &dynamodbstreams.StreamDescription{Shards: m.shards[idx : idx+1]}
+	if idx+1 < len(m.shards) {
+		description.LastEvaluatedShardId = m.shards[idx].ShardId
+	}
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: description}, nil
+}
+
+func (m *mockStreamsClient) GetShardIterator(input *dynamodbstreams.GetShardIteratorInput) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	next := 0
+	if *input.ShardIteratorType == dynamodbstreams.ShardIteratorTypeAfterSequenceNumber {
+		for idx, record := range m.records[*input.ShardId] {
+			if *record.Dynamodb.SequenceNumber == *input.SequenceNumber {
+				next = idx + 1
+			}
+		}
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(fmt.Sprintf("%s/%d", *input.ShardId, next))}, nil
+}
+
+func (m *mockStreamsClient) GetRecords(input *dynamodbstreams.GetRecordsInput) (*dynamodbstreams.GetRecordsOutput, error) {
+	// Returns 2 records per page
+	shard, position := path.Split(*input.ShardIterator)
+	shard = strings.TrimSuffix(shard, "/")
+	next, _ := strconv.Atoi(position)
+	records := m.records[shard][next:]
+	if len(records) > 2 {
+		records = records[:2]
+	}
+	next += len(records)
+	output := &dynamodbstreams.GetRecordsOutput{Records: records}
+	if next < len(m.records[shard]) || !m.closed[shard] {
+		output.NextShardIterator = aws.String(fmt.Sprintf("%s/%d", shard, next))
+	}
+	return output, nil
+}
+
+// streamRecord returns a stream record of the change of the given artist
+func streamRecord(event, sequence, artist string, minute int) *dynamodbstreams.Record {
+	keys := map[string]*dynamodb.AttributeValue{"artist": {S: aws.String(artist)}}
+	record := &dynamodbstreams.Record{EventName: aws.String(event), Dynamodb: &dynamodbstreams.StreamRecord{
+		Keys:                        keys,
+		SequenceNumber:              aws.String(sequence),
+		ApproximateCreationDateTime: aws.Time(time.Date(2019, 1, 1, 2, minute, 0, 0, time.UTC)),
+	}}
+	if event != storage.EventRemove {
+		record.Dynamodb.NewImage = map[string]*dynamodb.AttributeValue{"artist": {S: aws.String(artist)}, "songs": {SS: aws.StringSlice([]string{sequence})}}
+	}
+	return record
+}
+
+// segmentEvents returns the events and the sequence numbers of the changes
+// held by the given segment
+func segmentEvents(t *testing.T, store storage.BackupIface, folder *storage.FileInput) []string {
+	files, err := store.ListFiles(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []string{}
+	for _, file := range files {
+		if name := path.Base(*file.Path); name == "manifest" || strings.HasPrefix(name, "_") {
+			continue
+		}
+		doc, err := store.GetFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(*doc)
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			item := map[string]*dynamodb.AttributeValue{}
+			if err = json.Unmarshal([]byte(line), &item); err != nil {
+				t.Fatal(err)
+			}
+			change, _, err := storage.SplitChange(item)
+			if err != nil || change == nil {
+				t.Fatalf("Expecting a change in %s, got %v", line, err)
+			}
+			events = append(events, change.Event+" "+change.Sequence)
+		}
+	}
+	return events
+}
+
+func TestArchiveStream(t *testing.T) {
+	dynamoSvc = &mockStreamTableClient{viewType: dynamodb.StreamViewTypeNewAndOldImages}
+	svc := &mockStreamsClient{
+		shards: []*dynamodbstreams.Shard{
+			{ShardId: aws.String("shard-1")},
+			{ShardId: aws.String("shard-2"), ParentShardId: aws.String("shard-1")},
+		},
+		records: map[string][]*dynamodbstreams.Record{
+			"shard-1": {streamRecord("INSERT", "1", "Aerosmith", 1), streamRecord("MODIFY", "2", "Aerosmith", 2), streamRecord("INSERT", "3", "Queen", 3)},
+			"shard-2": {streamRecord("REMOVE", "4", "Aerosmith", 4)},
+		},
+		closed: map[string]bool{"shard-1": true},
+	}
+	location := "mem://tests/stream/myTable"
+	store, folder, err := storage.Open(location, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = archiveStream(svc, "myTable", location, storage.Config{}, time.Hour, 0, true, store, folder); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := store.ListBackups(folder)
+	if err != nil || len(backups) != 1 || backups[0].Status != storage.StatusComplete || aws.Int64Value(backups[0].Items) != 4 {
+		t.Fatalf("Expecting a complete segment of 4 changes, got %+v, %v", backups, err)
+	}
+	manifest, err := store.ReadManifest(backups[0].Folder)
+	if err != nil || manifest.Changes == nil || manifest.Changes.First.Minute() != 1 || manifest.Changes.Last.Minute() != 4 {
+		t.Errorf("Expecting the changes of the segment in its manifest, got %+v, %v", manifest, err)
+	}
+	expected := []string{"INSERT 1", "MODIFY 2", "INSERT 3", "REMOVE 4"}
+	if events := segmentEvents(t, store, backups[0].Folder); !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expecting the changes %v in order, got %v", expected, events)
+	}
+
+	// The next run continues from the checkpoint
+	svc.records["shard-2"] = append(svc.records["shard-2"], streamRecord("INSERT", "5", "Metallica", 5))
+	if err = archiveStream(svc, "myTable", location, storage.Config{}, time.Hour, 0, true, store, folder); err != nil {
+		t.Fatal(err)
+	}
+	if backups, err = store.ListBackups(folder); err != nil || len(backups) != 2 {
+		t.Fatalf("Expecting a 2nd segment, got %+v, %v", backups, err)
+	}
+	if events := segmentEvents(t, store, backups[1].Folder); !reflect.DeepEqual(events, []string{"INSERT 5"}) {
+		t.Errorf("Expecting only the new change in the 2nd segment, got %v", events)
+	}
+	a := &streamArchiver{streamARN: "arn:aws:dynamodb:us-east-1:123456789012:table/myTable/stream/1", store: store, folder: folder}
+	if err = a.loadCheckpoint(); err != nil || !reflect.DeepEqual(a.checkpoint.Shards, map[string]shardPosition{"shard-1": {Sequence: "3", Closed: true}, "shard-2": {Sequence: "5"}}) {
+		t.Errorf("Unexpected checkpoint: %+v, %v", a.checkpoint, err)
+	}
+
+	for _, viewType := range []string{"", dynamodb.StreamViewTypeKeysOnly} {
+		dynamoSvc = &mockStreamTableClient{viewType: viewType}
+		if err = archiveStream(svc, "myTable", location, storage.Config{}, time.Hour, 0, true, store, folder); err == nil {
+			t.Errorf("Expecting an error for a stream view type %q", viewType)
+		}
+	}
+}
+
+func TestReadShardsBounded(t *testing.T) {
+	// hot has more records than a pass reads, and is never closed
+	svc := &mockStreamsClient{
+		shards: []*dynamodbstreams.Shard{{ShardId: aws.String("hot")}, {ShardId: aws.String("cold")}},
+		records: map[string][]*dynamodbstreams.Record{
+			"hot":  {streamRecord("INSERT", "1", "Aerosmith", 1), streamRecord("INSERT", "2", "Queen", 2), streamRecord("INSERT", "3", "Metallica", 3), streamRecord("MODIFY", "4", "Queen", 4)},
+			"cold": {streamRecord("INSERT", "5", "Blondie", 5)},
+		},
+	}
+	location := "mem://tests/stream-bounded/myTable"
+	store, folder, err := storage.Open(location, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &streamArchiver{svc: svc, streamARN: "arn:aws:dynamodb:us-east-1:123456789012:table/myTable/stream/1", location: location, store: store, folder: folder, segmentPeriod: time.Hour, iterators: map[string]*string{}, shardPages: 1}
+	if err = a.loadCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each pass reads a page of 2 records of each shard
+	expected := []map[string]shardPosition{
+		{"hot": {Sequence: "2"}, "cold": {Sequence: "5"}},
+		{"hot": {Sequence: "4"}, "cold": {Sequence: "5"}},
+	}
+	for pass, positions := range expected {
+		if _, err = a.readShards(make(chan struct{})); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(a.checkpoint.Shards, positions) {
+			t.Errorf("Pass %d: expecting the positions %+v, got %+v", pass, positions, a.checkpoint.Shards)
+		}
+	}
+	if err = a.commitSegment(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// tableResult is the outcome of the backup of one of the tables of a
+// multi-table backup
+type tableResult struct {
+	Table  string `json:"table"`
+	URL    string `json:"url"`
+	Status string `json:"status"`
+	Items  *int64 `json:"items,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Start is the time the backup of the table started and Duration the
+	// time it took
+	Start    string `json:"start"`
+	Duration string `json:"duration"`
+}
+
+// backupSummary is written at the top of the target folder once all the
+// tables of a multi-table backup are done
+type backupSummary struct {
+	Time   string        `json:"time"`
+	Tables []tableResult `json:"tables"`
+	Failed int           `json:"failed"`
+}
+
+// listTables returns the names of all the tables of the account in the
+// region of the client
+func listTables(svc dynamodbiface.DynamoDBAPI) ([]string, error) {
+	names := []string{}
+	input := &dynamodb.ListTablesInput{}
+	for {
+		var output *dynamodb.ListTablesOutput
+		err := retry.do(func() (err error) {
+			output, err = svc.ListTables(input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, aws.StringValueSlice(output.TableNames)...)
+		if output.LastEvaluatedTableName == nil {
+			return names, nil
+		}
+		input.ExclusiveStartTableName = output.LastEvaluatedTableName
+	}
+}
+
+// selectTables returns the sorted names of the tables matching the given
+// comma separated list of table names, glob patterns such as prod-* and
+// regular expressions enclosed in slashes such as /^prod-(a|b)$/. The tables
+// are only listed if a pattern or a regular expression is given.
+func selectTables(svc dynamodbiface.DynamoDBAPI, selection string) ([]string, error) {
+	selected := map[string]bool{}
+	matchers := []func(string) bool{}
+	for _, entry := range strings.Split(selection, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/"):
+			re, err := regexp.Compile(entry[1 : len(entry)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %s: %s", entry, err)
+			}
+			matchers = append(matchers, re.MatchString)
+		case strings.ContainsAny(entry, "*?["):
+			pattern := entry
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %s", entry, err)
+			}
+			matchers = append(matchers, func(name string) bool {
+				matched, _ := path.Match(pattern, name)
+				return matched
+			})
+		default:
+			selected[entry] = true
+		}
+	}
+
+	if len(matchers) > 0 {
+		names, err := listTables(svc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the tables: %s", err)
+		}
+		for _, name := range names {
+			for _, match := range matchers {
+				if match(name) {
+					selected[name] = true
+					break
+				}
+			}
+		}
+	}
+
+	tables := []string{}
+	for name := range selected {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no table matches %q", selection)
+	}
+	return tables, nil
+}
+
+// backupTables makes each of the given backups in its sub-folder of the given
+// location, using a pool of the given number of workers. Each table has its own
+// data pipe and storage opened from cfg. With addDate, the backups are
+// made in a date folder of the sub-folders, the same for all the tables. The
+// summary of the backups is then written in the folder of the location, and
+// returned.
+func backupTables(backups []tableBackup, workers int, location string, addDate, resume bool, settings throughputSettings, incremental incrementalSettings, cfg storage.Config, store storage.BackupIface, folder *storage.FileInput) (*backupSummary, error) {
+	now := time.Now().UTC()
+	summary := &backupSummary{Time: now.Format(time.RFC3339), Tables: make([]tableResult, len(backups))}
+	summaryName := "summary.json"
+	if addDate {
+		summaryName = fmt.Sprintf("summary-%s.json", now.Format(storage.DateFolderFormat))
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				summary.Tables[idx] = backupOneTable(backups[idx], location, addDate, now, resume, settings, incremental, cfg)
+			}
+		}()
+	}
+	for idx := range backups {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, result := range summary.Tables {
+		if result.Status != storage.StatusComplete {
+			summary.Failed++
+		}
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return summary, err
+	}
+	return summary, store.Flush(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String(fmt.Sprintf("%s/%s", *folder.Path, summaryName))}, data)
+}
+
+// backupOneTable makes the given backup in its sub-folder of the given location
+// as part of a multi-table backup started at the given time
+func backupOneTable(backup tableBackup, location string, addDate bool, now time.Time, resume bool, settings throughputSettings, incrementalSettings incrementalSettings, cfg storage.Config) (result tableResult) {
+	start := time.Now()
+	result = tableResult{Table: backup.Table, Status: storage.StatusFailed, Start: start.UTC().Format(time.RFC3339)}
+	defer func() {
+		result.Duration = time.Since(start).Round(time.Second).String()
+	}()
+
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	tracker := newScanTracker()
+	cfg.DataPipe = dataPipe
+	cfg.Checkpointer = tracker.positions
+	result.URL = strings.TrimSuffix(location, "/") + "/" + backup.Folder
+	if backup.Error != "" {
+		result.Error = backup.Error
+		return result
+	}
+	store, folder, err := storage.Open(result.URL, &cfg)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	incremental, err := newIncrementalBackup(incrementalSettings, store, folder)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if addDate {
+		folder.Path = aws.String(fmt.Sprintf("%s/%s", *folder.Path, now.Format(storage.DateFolderFormat)))
+		result.URL += "/" + now.Format(storage.DateFolderFormat)
+	}
+
+	log.Printf("Backing up the table %s to %s\n", backup.Table, result.URL)
+	if err = backupTable(backup.Table, backup.BatchSize, backup.WaitPeriod, backup.ScanSegments, *folder.Bucket, *folder.Path, false, resume, settings, incremental, tracker, dataPipe, store); err != nil {
+		log.Printf("[ERROR] The backup of the table %s failed: %s\n", backup.Table, err)
+		result.Error = err.Error()
+		return result
+	}
+	if info, err := store.Describe(folder); err == nil {
+		result.Status, result.Items = info.Status, info.Items
+	}
+	return result
+}
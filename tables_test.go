@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/VEVO/dynamodbdump/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// struct to mock the Dynamo calls of a backup of several tables
+type mockTablesDynamoDBClient struct {
+	mockSchemaDynamoDBClient
+	scans mockDynamoDBClient
+}
+
+func (m *mockTablesDynamoDBClient) ListTables(input *dynamodb.ListTablesInput) (*dynamodb.ListTablesOutput, error) {
+	if input.ExclusiveStartTableName == nil {
+		return &dynamodb.ListTablesOutput{TableNames: aws.StringSlice([]string{"prod-a", "prod-b"}), LastEvaluatedTableName: aws.String("prod-b")}, nil
+	}
+	return &dynamodb.ListTablesOutput{TableNames: aws.StringSlice([]string{"prod-c", "staging-a", "broken"})}, nil
+}
+
+func (m *mockTablesDynamoDBClient) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	if *input.TableName == "broken" {
+		return nil, fmt.Errorf("access denied")
+	}
+	return m.mockSchemaDynamoDBClient.DescribeTable(input)
+}
+
+func (m *mockTablesDynamoDBClient) ScanPages(params *dynamodb.ScanInput, pager func(*dynamodb.ScanOutput, bool) bool) error {
+	return m.scans.ScanPages(params, pager)
+}
+
+func TestSelectTables(t *testing.T) {
+	svc := &mockTablesDynamoDBClient{}
+	testCases := []struct {
+		selection string
+		expected  []string
+	}{
+		{selection: "b, a", expected: []string{"a", "b"}},
+		{selection: "prod-*", expected: []string{"prod-a", "prod-b", "prod-c"}},
+		{selection: "/^(staging|prod)-a$/,prod-c", expected: []string{"prod-a", "prod-c", "staging-a"}},
+		{selection: "dev-*", expected: nil},
+		{selection: "/(/", expected: nil},
+		{selection: "prod-[", expected: nil},
+	}
+	for _, tc := range testCases {
+		tables, err := selectTables(svc, tc.selection)
+		if tc.expected == nil {
+			if err == nil {
+				t.Errorf("Expecting an error for %q, got %v", tc.selection, tables)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(tables, tc.expected) {
+			t.Errorf("Expecting %v for %q, got %v, %v", tc.expected, tc.selection, tables, err)
+		}
+	}
+}
+
+func TestBackupTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dynamodbdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dynamoSvc = &mockTablesDynamoDBClient{}
+	location := "file://" + filepath.ToSlash(dir) + "/backups"
+	store, folder, err := storage.Open(location, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []string{"prod-a", "prod-b", "broken", "mistagged"}
+	backups := defaultBackups(tables, tableBackup{BatchSize: 10, ScanSegments: 1})
+	backups[3].Error = "invalid tag dynamodbdump:batch-size=ten"
+	summary, err := backupTables(backups, 2, location, true, false, throughputSettings{}, incrementalSettings{}, storage.Config{}, store, folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Failed != 2 || len(summary.Tables) != len(tables) {
+		t.Fatalf("Unexpected summary: %+v", summary)
+	}
+	for idx, result := range summary.Tables {
+		if result.Table != tables[idx] {
+			t.Errorf("Expecting the results in the order of the tables, got %s at %d", result.Table, idx)
+		}
+		if result.Table == "broken" {
+			if result.Status != storage.StatusFailed || result.Error == "" {
+				t.Errorf("Expecting the backup of the broken table to fail, got %+v", result)
+			}
+			continue
+		}
+		if result.Table == "mistagged" {
+			if result.Status != storage.StatusFailed || result.Error != backups[3].Error {
+				t.Errorf("Expecting the table with an invalid tag to be reported as failed, got %+v", result)
+			}
+			continue
+		}
+		if result.Status != storage.StatusComplete || aws.Int64Value(result.Items) != int64(len(dataSet)) {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+		backups, err := store.ListBackups(&storage.FileInput{Bucket: folder.Bucket, Path: aws.String(filepath.Join(*folder.Path, result.Table))})
+		if err != nil || len(backups) != 1 || backups[0].URL != result.URL {
+			t.Errorf("Expecting the backup of %s in a date folder of its sub-folder, got %+v, %v", result.Table, backups, err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "backups", "summary-*.json"))
+	if len(files) != 1 {
+		t.Fatalf("Expecting a summary file, got %v", files)
+	}
+	data, _ := ioutil.ReadFile(files[0])
+	written := &backupSummary{}
+	if err = json.Unmarshal(data, written); err != nil || !reflect.DeepEqual(written, summary) {
+		t.Errorf("Unexpected summary file: %s, %v", data, err)
+	}
+}
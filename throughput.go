@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// capacityRefreshInterval is the time between 2 reads of the provisioned
+// capacity of the table, which can be changed by auto scaling while running
+const capacityRefreshInterval = time.Minute
+
+// perItemSmoothing is the weight given to the last measure of the capacity
+// consumed per item in its moving average
+const perItemSmoothing = 0.3
+
+// minPerItem is the lowest capacity consumed per item taken into account, to
+// keep the estimates finite when DynamoDB reports no consumed capacity
+const minPerItem = 0.001
+
+// throughputSettings are the options driving the adaptive throughput control
+type throughputSettings struct {
+	// utilization is the share of the provisioned capacity of the table to
+	// consume. The adaptive control is disabled if it is not positive.
+	utilization float64
+	// ceiling is the number of capacity units to consume per second on
+	// on-demand tables. It also caps the target of provisioned tables when set.
+	ceiling float64
+}
+
+// controller returns the throughputController of the reads (or the writes) of
+// the given table or nil if the adaptive throughput control is disabled
+func (s throughputSettings) controller(svc dynamodbiface.DynamoDBAPI, tableName string, write bool) (*throughputController, error) {
+	if s.utilization <= 0 {
+		return nil, nil
+	}
+	if s.utilization > 1 {
+		return nil, fmt.Errorf("the target utilization has to be between 0 and 1, got %g", s.utilization)
+	}
+	t := &throughputController{svc: svc, tableName: tableName, write: write, settings: s, perItem: 1}
+	target, err := t.target()
+	if err != nil {
+		return nil, err
+	}
+	t.refreshed = time.Now()
+	t.limiter = newRateLimiter(target, target)
+	log.Printf("Targeting %.1f capacity units per second on %s\n", target, tableName)
+	return t, nil
+}
+
+// throughputController paces the requests made to a table so that they consume
+// the target capacity. The capacity consumed per item is measured from the
+// ConsumedCapacity returned by DynamoDB.
+type throughputController struct {
+	mu        sync.Mutex
+	svc       dynamodbiface.DynamoDBAPI
+	tableName string
+	// write is set when the controller paces writes instead of reads
+	write     bool
+	settings  throughputSettings
+	limiter   *rateLimiter
+	perItem   float64
+	refreshed time.Time
+}
+
+// target returns the number of capacity units to consume per second according
+// to the current capacity of the table
+func (t *throughputController) target() (float64, error) {
+	var result *dynamodb.DescribeTableOutput
+	err := retry.do(func() (err error) {
+		result, err = t.svc.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(t.tableName)})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	var provisioned int64
+	if throughput := result.Table.ProvisionedThroughput; throughput != nil {
+		if t.write {
+			provisioned = aws.Int64Value(throughput.WriteCapacityUnits)
+		} else {
+			provisioned = aws.Int64Value(throughput.ReadCapacityUnits)
+		}
+	}
+	onDemand := result.Table.BillingModeSummary != nil && aws.StringValue(result.Table.BillingModeSummary.BillingMode) == dynamodb.BillingModePayPerRequest
+	if onDemand || provisioned == 0 {
+		if t.settings.ceiling <= 0 {
+			return 0, fmt.Errorf("the table %s is on-demand, a capacity ceiling is required to control its throughput", t.tableName)
+		}
+		return t.settings.ceiling, nil
+	}
+	target := float64(provisioned) * t.settings.utilization
+	if t.settings.ceiling > 0 && t.settings.ceiling < target {
+		target = t.settings.ceiling
+	}
+	return target, nil
+}
+
+// refresh reads the capacity of the table again once capacityRefreshInterval
+// has passed and updates the rate of the limiter accordingly
+func (t *throughputController) refresh() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.refreshed) < capacityRefreshInterval {
+		return
+	}
+	t.refreshed = time.Now()
+	target, err := t.target()
+	if err != nil {
+		log.Printf("[WARNING] Unable to refresh the capacity of %s, keeping the current target: %s\n", t.tableName, err)
+		return
+	}
+	if target != t.limiter.currentRate() {
+		log.Printf("Targeting %.1f capacity units per second on %s\n", target, t.tableName)
+		t.limiter.setRate(target, target)
+	}
+}
+
+// consumed registers the capacity consumed by a request on the given number
+// of items
+func (t *throughputController) consumed(capacity float64, items int64) {
+	if items <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.perItem = (1-perItemSmoothing)*t.perItem + perItemSmoothing*capacity/float64(items)
+	if t.perItem < minPerItem {
+		t.perItem = minPerItem
+	}
+}
+
+// waitItems blocks until the estimated capacity needed by the given number of
+// items can be consumed
+func (t *throughputController) waitItems(items int64) {
+	t.refresh()
+	t.mu.Lock()
+	capacity := t.perItem * float64(items)
+	t.mu.Unlock()
+	t.limiter.wait(capacity)
+}
+
+// waitCapacity blocks until the given capacity, already consumed, is allowed
+func (t *throughputController) waitCapacity(capacity float64) {
+	t.refresh()
+	t.limiter.wait(capacity)
+}
+
+// scanLimit returns the number of items a segment should read in a page so
+// that all the segments together consume about one second of the target
+// capacity per page. The result is between 1 and maxItems if maxItems is
+// positive.
+func (t *throughputController) scanLimit(maxItems, segments int64) int64 {
+	if segments < 1 {
+		segments = 1
+	}
+	t.mu.Lock()
+	limit := t.limiter.currentRate() / float64(segments) / t.perItem
+	t.mu.Unlock()
+	if maxItems > 0 && limit > float64(maxItems) {
+		limit = float64(maxItems)
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	return int64(limit)
+}
+
+// consumedCapacity returns the total capacity units of the given
+// ConsumedCapacity list
+func consumedCapacity(capacities []*dynamodb.ConsumedCapacity) float64 {
+	var total float64
+	for _, capacity := range capacities {
+		if capacity != nil {
+			total += aws.Float64Value(capacity.CapacityUnits)
+		}
+	}
+	return total
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// struct to mock a paginated Dynamo scan on a table with the given capacity
+type mockCapacityDynamoDBClient struct {
+	mockPagedDynamoDBClient
+	readCapacity, writeCapacity int64
+	onDemand                    bool
+	scans                       int
+}
+
+func (m *mockCapacityDynamoDBClient) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	table := &dynamodb.TableDescription{
+		TableName: input.TableName,
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughputDescription{
+			ReadCapacityUnits:  aws.Int64(m.readCapacity),
+			WriteCapacityUnits: aws.Int64(m.writeCapacity),
+		},
+	}
+	if m.onDemand {
+		table.BillingModeSummary = &dynamodb.BillingModeSummary{BillingMode: aws.String(dynamodb.BillingModePayPerRequest)}
+	}
+	return &dynamodb.DescribeTableOutput{Table: table}, nil
+}
+
+func (m *mockCapacityDynamoDBClient) ScanPages(params *dynamodb.ScanInput, pager func(*dynamodb.ScanOutput, bool) bool) error {
+	m.scans++
+	return m.mockPagedDynamoDBClient.ScanPages(params, pager)
+}
+
+func TestThroughputTarget(t *testing.T) {
+	provisioned := &mockCapacityDynamoDBClient{readCapacity: 40, writeCapacity: 10}
+	onDemand := &mockCapacityDynamoDBClient{onDemand: true}
+	testCases := []struct {
+		name     string
+		svc      *mockCapacityDynamoDBClient
+		settings throughputSettings
+		write    bool
+		expected float64
+		err      bool
+	}{
+		{name: "read", svc: provisioned, settings: throughputSettings{utilization: 0.5}, expected: 20},
+		{name: "write", svc: provisioned, settings: throughputSettings{utilization: 0.5}, write: true, expected: 5},
+		{name: "capped", svc: provisioned, settings: throughputSettings{utilization: 0.5, ceiling: 3}, expected: 3},
+		{name: "on-demand", svc: onDemand, settings: throughputSettings{utilization: 0.5, ceiling: 7}, expected: 7},
+		{name: "on-demand without ceiling", svc: onDemand, settings: throughputSettings{utilization: 0.5}, err: true},
+		{name: "invalid utilization", svc: provisioned, settings: throughputSettings{utilization: 1.5}, err: true},
+	}
+	for _, tc := range testCases {
+		controller, err := tc.settings.controller(tc.svc, "myTable", tc.write)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expecting an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+			continue
+		}
+		if rate := controller.limiter.currentRate(); rate != tc.expected {
+			t.Errorf("%s: expecting a target of %g, got %g", tc.name, tc.expected, rate)
+		}
+	}
+
+	controller, err := throughputSettings{}.controller(provisioned, "myTable", false)
+	if controller != nil || err != nil {
+		t.Errorf("Expecting no controller without target utilization, got %v, %v", controller, err)
+	}
+}
+
+func TestThroughputScanLimit(t *testing.T) {
+	controller, err := throughputSettings{utilization: 0.5}.controller(&mockCapacityDynamoDBClient{readCapacity: 40}, "myTable", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// 1 capacity unit per item is assumed until measured
+	if limit := controller.scanLimit(1000, 2); limit != 10 {
+		t.Errorf("Expecting a limit of 10, got %d", limit)
+	}
+	// Items consuming half a unit allow bigger pages
+	for i := 0; i < 50; i++ {
+		controller.consumed(5, 10)
+	}
+	if math.Abs(controller.perItem-0.5) > 0.001 {
+		t.Errorf("Expecting 0.5 capacity unit per item, got %g", controller.perItem)
+	}
+	if limit := controller.scanLimit(1000, 2); limit != 19 && limit != 20 {
+		t.Errorf("Expecting a limit of 20, got %d", limit)
+	}
+	if limit := controller.scanLimit(5, 2); limit != 5 {
+		t.Errorf("Expecting the limit to be capped to 5, got %d", limit)
+	}
+	// No consumed capacity keeps the estimates finite
+	for i := 0; i < 50; i++ {
+		controller.consumed(0, 10)
+	}
+	if limit := controller.scanLimit(0, 1); limit <= 0 {
+		t.Errorf("Expecting a positive limit, got %d", limit)
+	}
+}
+
+func TestScanWithThroughput(t *testing.T) {
+	svc := &mockCapacityDynamoDBClient{readCapacity: 1000}
+	controller, err := throughputSettings{utilization: 1}.controller(svc, "myTable", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	dataPipe := make(chan map[string]*dynamodb.AttributeValue)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ParallelTableToChannel(svc, "myTable", 2, 0, 1, nil, controller, nil, dataPipe)
+	}()
+	received := 0
+	for range dataPipe {
+		received++
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Unexpected error during the scan: %s", err)
+	}
+	if received != len(pagedDataSet) {
+		t.Errorf("Expecting %d items, got %d", len(pagedDataSet), received)
+	}
+	// Each page of 2 items is requested on its own
+	if svc.scans != 3 {
+		t.Errorf("Expecting 3 scan requests, got %d", svc.scans)
+	}
+}